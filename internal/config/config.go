@@ -1,15 +1,57 @@
 package config
 
 var Global struct {
-	LogLevel              string `id:"log-level" short:"l" desc:"verbosity level for logs" default:"warn"`
-	HCloudToken           string `id:"hcloud-token" desc:"API token for HCloud access"`
-	ServiceLabelSelector  string `id:"service-label-selector" desc:"label selector used to match services" default:"hcloud-ip-floater.cstl.dev/ignore!=true"`
-	FloatingLabelSelector string `id:"floating-label-selector" desc:"label selector used to match floating IPs" default:""`
+	LogLevel              string   `id:"log-level" short:"l" desc:"verbosity level for logs" default:"warn"`
+	HCloudToken           string   `id:"hcloud-token" desc:"API token for HCloud access"`
+	ServiceLabelSelector  string   `id:"service-label-selector" desc:"label selector used to match services" default:"hcloud-ip-floater.cstl.dev/ignore!=true"`
+	FloatingLabelSelector string   `id:"floating-label-selector" desc:"label selector used to match floating IPs" default:""`
+	NodeLabelSelector     string   `id:"node-label-selector" desc:"label selector for nodes IPs may be attached to" default:""`
+	ExcludedTaints        []string `id:"excluded-taints" desc:"nodes with any of these taints (key[=value][:effect]) never get IPs attached" default:"ToBeDeletedByClusterAutoscaler"`
+	NodeMapping           string   `id:"node-mapping" desc:"how to map nodes to hcloud servers: auto, provider-id, label or name" default:"auto"`
+	NodeServerLabel       string   `id:"node-server-label" desc:"node label containing the hcloud server ID or name, used by the label node mapping"`
+	LoadBalancerClass     string   `id:"load-balancer-class" desc:"spec.loadBalancerClass of services handled by this controller" default:"hcloud-ip-floater.cstl.dev/floating-ip"`
+	UnclassedServices     string   `id:"unclassed-services" desc:"how to handle LoadBalancer services without load balancer class: claim, ignore or known-fip" default:"claim"`
+	AllocateIPs           bool     `id:"allocate-ips" desc:"allocate floating IPs to LoadBalancer services, instead of relying on e.g. MetalLB" default:"false"`
+	AliasIPNetwork        string   `id:"alias-ip-network" desc:"hcloud network (ID or name) in which private service IPs are floated via alias IPs"`
+	RouteNetwork          string   `id:"route-network" desc:"hcloud network (ID or name) whose routes point virtual service IPs at the selected node"`
+	StickyAttachments     bool     `id:"sticky-attachments" desc:"keep IPs on their current node as long as it has ready pods, instead of always using the hash-elected node" default:"false"`
+	AdoptAttachments      bool     `id:"adopt-attachments" desc:"on startup, keep IPs on their current node as long as it has ready pods, instead of re-electing from scratch" default:"true"`
+	ReadyHoldDownSeconds  int      `id:"ready-hold-down" desc:"time a pod must have been continuously ready before its node may receive IPs" default:"0"`
+	MoveCooldownSeconds   int      `id:"move-cooldown" desc:"minimum time between moves of the same IP, unless its node becomes ineligible" default:"0"`
+	ListenAddress         string   `id:"listen-address" desc:"address to serve prometheus metrics and health probes on" default:":8080"`
+
+	// Hetzner Robot failover IPs for dedicated servers
+	RobotUser        string `id:"robot-user" desc:"Hetzner Robot webservice user; enables failover IPs"`
+	RobotPassword    string `id:"robot-password" desc:"Hetzner Robot webservice password"`
+	RobotServerLabel string `id:"robot-server-label" desc:"node label containing the Hetzner Robot server number of dedicated servers" default:"hcloud-ip-floater.cstl.dev/robot-server-number"`
+
+	// custom IP movers
+	ExternalCommand        string   `id:"external-command" desc:"command (with whitespace-separated arguments) run with a JSON payload on stdin to move service IPs"`
+	ExternalWebhook        string   `id:"external-webhook" desc:"URL receiving a JSON payload via POST to move service IPs"`
+	ExternalIPRanges       []string `id:"external-ip-ranges" desc:"CIDRs of service IPs moved by the external command or webhook (default: all IPs not handled otherwise)"`
+	ExternalTimeoutSeconds int      `id:"external-timeout" desc:"timeout for the external command or webhook" default:"30"`
 
 	// optional MetalLB integration
-	MetalLBNamespace  string `id:"metallb-namespace" desc:"namespace to create MetalLB ConfigMap"`
-	MetalLBConfigName string `id:"metallb-config-name" desc:"name of ConfigMap resource used by MetalLB"`
+	MetalLBMode            string `id:"metallb-mode" desc:"how to configure MetalLB: configmap (MetalLB < 0.13) or crd" default:"configmap"`
+	MetalLBNamespace       string `id:"metallb-namespace" desc:"namespace to create MetalLB ConfigMap or resources"`
+	MetalLBConfigName      string `id:"metallb-config-name" desc:"name of ConfigMap resource used by MetalLB"`
+	MetalLBPoolLabel       string `id:"metallb-pool-label" desc:"floating IP label used to split floating IPs into separate MetalLB address pools"`
+	MetalLBAutoAssignLabel string `id:"metallb-auto-assign-label" desc:"floating IP label which disables MetalLB auto-assignment of its pool when set to false"`
+
+	// leader election for running multiple replicas
+	LeaderElection          bool   `id:"leader-election" desc:"use a kubernetes lease to elect a single active replica" default:"true"`
+	LeaderElectionNamespace string `id:"leader-election-namespace" desc:"namespace of the leader election lease" default:"hcloud-ip-floater"`
+	LeaderElectionName      string `id:"leader-election-name" desc:"name of the leader election lease" default:"hcloud-ip-floater"`
+	LeaderElectionIdentity  string `id:"leader-election-identity" desc:"identity of this replica in the leader election (defaults to hostname)"`
+	LeaseSeconds            int    `id:"lease-duration" desc:"duration standby replicas wait before taking over the lease" default:"15" opts:"hidden"`
+	RenewSeconds            int    `id:"lease-renew-deadline" desc:"duration the leader retries renewing the lease before giving up" default:"10" opts:"hidden"`
+	RetrySeconds            int    `id:"lease-retry-period" desc:"interval between lease acquisition/renewal attempts" default:"2" opts:"hidden"`
 
-	SyncSeconds int  `id:"sync-interval" desc:"interval to sync with k8s and poll from hcloud" default:"300" opts:"hidden"`
-	Version     bool `id:"version" desc:"show version and quit" opts:"hidden"`
+	Workers               int  `id:"workers" desc:"number of services handled concurrently" default:"4"`
+	APITimeoutSeconds     int  `id:"api-timeout" desc:"timeout for API calls used to sync state" default:"30"`
+	AttachTimeoutSeconds  int  `id:"attach-timeout" desc:"timeout for attaching an IP, including waiting for it to complete" default:"120"`
+	ShutdownGraceSeconds  int  `id:"shutdown-grace-period" desc:"time in-flight attachments may take to finish on shutdown" default:"20"`
+	SyncSeconds           int  `id:"sync-interval" desc:"interval to sync with k8s and poll from hcloud" default:"300" opts:"hidden"`
+	HealthDeadlineSeconds int  `id:"health-deadline" desc:"time a reconciliation step or the hcloud poll loop may be stuck before reporting unhealthy; must exceed attach-timeout" default:"300"`
+	Version               bool `id:"version" desc:"show version and quit" opts:"hidden"`
 }
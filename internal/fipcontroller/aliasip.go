@@ -0,0 +1,118 @@
+package fipcontroller
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/hetznercloud/hcloud-go/hcloud"
+)
+
+// aliasIPBackend floats private IPs inside an hcloud network by moving them between the alias IPs of servers
+type aliasIPBackend struct {
+	networkCache
+}
+
+func (b *aliasIPBackend) name() string {
+	return "alias-ip"
+}
+
+func (b *aliasIPBackend) handles(ip net.IP) bool {
+	network := b.getNetwork()
+
+	return network != nil && network.IPRange != nil && network.IPRange.Contains(ip)
+}
+
+func (b *aliasIPBackend) attach(ctx context.Context, ip net.IP, att attachment) (bool, error) {
+	network := b.getNetwork()
+	if network == nil {
+		return false, fmt.Errorf("network %s not synced yet", b.networkRef)
+	}
+
+	target, err := getServer(ctx, b.hcloudClient, att)
+	if err != nil {
+		return false, err
+	}
+
+	if privateNet(target, network) == nil {
+		return false, fmt.Errorf("server %s is not attached to network %s", target.Name, network.Name)
+	}
+
+	// alias IPs are only visible on the servers, so we have to look at all of them to find the current holder
+	servers, err := b.hcloudClient.Server().All(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	var changed bool
+	var targetHasIP bool
+
+	for _, server := range servers {
+		pn := privateNet(server, network)
+		if pn == nil || !containsIP(pn.Aliases, ip) {
+			continue
+		}
+
+		if server.ID == target.ID {
+			targetHasIP = true
+			continue
+		}
+
+		// the same IP on several servers would be ambiguous, so remove it before adding it to the target
+		if err := b.changeAliasIPs(ctx, server, network, removeIP(pn.Aliases, ip)); err != nil {
+			return changed, fmt.Errorf("could not remove alias IP from server %s: %w", server.Name, err)
+		}
+		changed = true
+	}
+
+	if targetHasIP {
+		return changed, nil
+	}
+
+	aliasIPs := append(append([]net.IP{}, privateNet(target, network).Aliases...), ip)
+	if err := b.changeAliasIPs(ctx, target, network, aliasIPs); err != nil {
+		return changed, fmt.Errorf("could not add alias IP to server %s: %w", target.Name, err)
+	}
+
+	return true, nil
+}
+
+func (b *aliasIPBackend) changeAliasIPs(ctx context.Context, server *hcloud.Server, network *hcloud.Network, aliasIPs []net.IP) error {
+	act, _, err := b.hcloudClient.Server().ChangeAliasIPs(ctx, server, hcloud.ServerChangeAliasIPsOpts{
+		Network:  network,
+		AliasIPs: aliasIPs,
+	})
+	if err != nil {
+		return err
+	}
+
+	return waitForAction(ctx, b.hcloudClient, act)
+}
+
+func privateNet(server *hcloud.Server, network *hcloud.Network) *hcloud.ServerPrivateNet {
+	for i := range server.PrivateNet {
+		if server.PrivateNet[i].Network != nil && server.PrivateNet[i].Network.ID == network.ID {
+			return &server.PrivateNet[i]
+		}
+	}
+	return nil
+}
+
+func containsIP(ips []net.IP, ip net.IP) bool {
+	for _, other := range ips {
+		if other.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func removeIP(ips []net.IP, ip net.IP) []net.IP {
+	res := make([]net.IP, 0, len(ips))
+	for _, other := range ips {
+		if !other.Equal(ip) {
+			res = append(res, other)
+		}
+	}
+	return res
+}
@@ -0,0 +1,114 @@
+package fipcontroller
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/hetznercloud/hcloud-go/hcloud"
+)
+
+// backend moves service IPs which are not hcloud floating IPs between nodes
+type backend interface {
+	// name identifies the backend in logs
+	name() string
+	// sync refreshes any state cached from the backend's API; called along with the floating IP sync
+	sync(ctx context.Context) error
+	// handles returns whether the backend is responsible for the given service IP
+	handles(ip net.IP) bool
+	// attach moves the service IP to the attachment's node, returning whether anything had to be changed
+	attach(ctx context.Context, ip net.IP, att attachment) (bool, error)
+}
+
+// provider is implemented by backends which own IPs of their own, like floating IPs, that can be allocated to services.
+// hcloud floating IPs are not a provider: unlike the other backends, the hcloud API tells us where each of them is
+// attached, which the controller itself relies on to detect drift on every poll, to find the current node of a service
+// for stickiness and adoption, to keep services sharing an IPv6 network together and to generate MetalLB's config.
+type provider interface {
+	backend
+	// floatingIPs returns the IPs found during the last sync
+	floatingIPs() []FloatingIP
+}
+
+// backendFor returns the first backend responsible for the given service IP, if any. Backends are tried in order, so
+// an IP is unknown until all backends before the responsible one have synced: one of them might own it.
+func (fc *Controller) backendFor(ip string) backend {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return nil
+	}
+
+	for _, b := range fc.backends {
+		if !fc.backendSynced(b) {
+			return nil
+		}
+		if b.handles(parsed) {
+			return b
+		}
+	}
+
+	return nil
+}
+
+// getServer looks up the hcloud server referenced by an attachment
+func getServer(ctx context.Context, hc hcloudClienter, att attachment) (*hcloud.Server, error) {
+	var server *hcloud.Server
+	var err error
+	if att.server.id != 0 {
+		server, _, err = hc.Server().GetByID(ctx, att.server.id)
+	} else {
+		server, _, err = hc.Server().GetByName(ctx, att.server.name)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// extra safety for https://github.com/costela/hcloud-ip-floater/issues/8
+	if server == nil {
+		return nil, fmt.Errorf("could not find server %s for node %s", att.server, att.node)
+	}
+
+	return server, nil
+}
+
+// waitForAction blocks until the given hcloud action has finished
+func waitForAction(ctx context.Context, hc hcloudClienter, act *hcloud.Action) error {
+	_, errc := hc.Action().WatchProgress(ctx, act)
+	return <-errc
+}
+
+// networkCache keeps the last synced state of an hcloud network, for backends operating inside it
+type networkCache struct {
+	hcloudClient hcloudClienter
+	networkRef   string // ID or name
+
+	network   *hcloud.Network
+	networkMu sync.RWMutex
+}
+
+func (nc *networkCache) sync(ctx context.Context) error {
+	ctx, cancel := APIContext(ctx)
+	defer cancel()
+
+	network, _, err := nc.hcloudClient.Network().Get(ctx, nc.networkRef)
+	if err != nil {
+		return err
+	}
+	if network == nil {
+		return fmt.Errorf("could not find network %s", nc.networkRef)
+	}
+
+	nc.networkMu.Lock()
+	nc.network = network
+	nc.networkMu.Unlock()
+
+	return nil
+}
+
+func (nc *networkCache) getNetwork() *hcloud.Network {
+	nc.networkMu.RLock()
+	defer nc.networkMu.RUnlock()
+
+	return nc.network
+}
@@ -0,0 +1,163 @@
+package fipcontroller
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// externalPayload is sent to the external command or webhook for each IP to be moved
+type externalPayload struct {
+	IP           string   `json:"ip"`
+	Node         string   `json:"node"`
+	PreviousNode string   `json:"previous_node,omitempty"`
+	Services     []string `json:"services"`
+}
+
+// externalBackend moves service IPs by running a user-supplied command or calling a webhook. Since it cannot observe
+// where an IP currently is, it remembers the last successful attachment of each IP.
+type externalBackend struct {
+	command []string // executable and its arguments
+	webhook string
+	ranges  []*net.IPNet // empty means all IPs not handled by other backends
+	timeout time.Duration
+
+	httpClient *http.Client
+
+	nodes   map[string]string // IP → node it was last successfully moved to
+	nodesMu sync.Mutex
+}
+
+func newExternalBackend(command, webhook string, ranges []*net.IPNet, timeout time.Duration) *externalBackend {
+	return &externalBackend{
+		command:    strings.Fields(command),
+		webhook:    webhook,
+		ranges:     ranges,
+		timeout:    timeout,
+		httpClient: &http.Client{},
+		nodes:      make(map[string]string),
+	}
+}
+
+// ParseIPRanges parses a list of CIDRs
+func ParseIPRanges(cidrs []string) ([]*net.IPNet, error) {
+	ranges := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		if cidr = strings.TrimSpace(cidr); cidr == "" {
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		ranges = append(ranges, ipNet)
+	}
+
+	return ranges, nil
+}
+
+func (b *externalBackend) name() string {
+	return "external"
+}
+
+func (b *externalBackend) sync(context.Context) error {
+	return nil
+}
+
+func (b *externalBackend) handles(ip net.IP) bool {
+	if len(b.ranges) == 0 {
+		return true
+	}
+
+	for _, ipNet := range b.ranges {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (b *externalBackend) attach(ctx context.Context, ip net.IP, att attachment) (bool, error) {
+	b.nodesMu.Lock()
+	previousNode := b.nodes[ip.String()]
+	b.nodesMu.Unlock()
+
+	if previousNode == att.node {
+		return false, nil
+	}
+
+	payload := externalPayload{
+		IP:           ip.String(),
+		Node:         att.node,
+		PreviousNode: previousNode,
+		Services:     []string{},
+	}
+	if att.svc != nil {
+		payload.Services = append(payload.Services, att.svc.Namespace+"/"+att.svc.Name)
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return false, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, b.timeout)
+	defer cancel()
+
+	if len(b.command) > 0 {
+		if err := b.runCommand(ctx, body); err != nil {
+			return false, err
+		}
+	}
+	if b.webhook != "" {
+		if err := b.callWebhook(ctx, body); err != nil {
+			return false, err
+		}
+	}
+
+	b.nodesMu.Lock()
+	b.nodes[ip.String()] = att.node
+	b.nodesMu.Unlock()
+
+	return true, nil
+}
+
+func (b *externalBackend) runCommand(ctx context.Context, payload []byte) error {
+	cmd := exec.CommandContext(ctx, b.command[0], b.command[1:]...)
+	cmd.Stdin = bytes.NewReader(payload)
+
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("command %s failed: %w: %s", b.command[0], err, bytes.TrimSpace(out))
+	}
+
+	return nil
+}
+
+func (b *externalBackend) callWebhook(ctx context.Context, payload []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, b.webhook, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := b.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook %s returned %s", b.webhook, resp.Status)
+	}
+
+	return nil
+}
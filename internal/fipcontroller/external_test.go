@@ -0,0 +1,177 @@
+package fipcontroller
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os/exec"
+	"reflect"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestExternalBackendCommand(t *testing.T) {
+	for _, command := range []string{"true", "false"} {
+		if _, err := exec.LookPath(command); err != nil {
+			t.Skipf("%s not available: %s", command, err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		command string
+		wantErr bool
+	}{
+		{"success", "true", false},
+		{"failure", "false", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newExternalBackend(tt.command, "", nil, time.Second)
+			ip := net.ParseIP("192.0.2.1")
+
+			changed, err := b.attach(context.Background(), ip, attachment{node: "node"})
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				if changed {
+					t.Error("got changed on error")
+				}
+				// failed moves are not remembered, so they are retried
+				if _, found := b.nodes[ip.String()]; found {
+					t.Error("failed move was remembered")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !changed {
+				t.Error("expected change")
+			}
+
+			if changed, err := b.attach(context.Background(), ip, attachment{node: "node"}); err != nil || changed {
+				t.Errorf("got changed %t (%v) when attaching to the same node again", changed, err)
+			}
+		})
+	}
+}
+
+func TestExternalBackendWebhook(t *testing.T) {
+	var (
+		payloads []externalPayload
+		status   = http.StatusOK
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("unexpected request: %s with content type %q", r.Method, r.Header.Get("Content-Type"))
+		}
+		var payload externalPayload
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Errorf("could not decode payload: %s", err)
+		}
+		payloads = append(payloads, payload)
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+
+	b := newExternalBackend("", server.URL, nil, time.Second)
+	ip := net.ParseIP("192.0.2.1")
+	svc := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "svc"}}
+
+	steps := []struct {
+		name        string
+		node        string
+		status      int
+		wantChanged bool
+		wantErr     bool
+		wantPayload *externalPayload // nil if no call is expected
+	}{
+		{
+			name:        "first move",
+			node:        "a",
+			status:      http.StatusOK,
+			wantChanged: true,
+			wantPayload: &externalPayload{IP: "192.0.2.1", Node: "a", Services: []string{"default/svc"}},
+		},
+		{
+			name:   "same node",
+			node:   "a",
+			status: http.StatusOK,
+		},
+		{
+			name:        "error status",
+			node:        "b",
+			status:      http.StatusInternalServerError,
+			wantErr:     true,
+			wantPayload: &externalPayload{IP: "192.0.2.1", Node: "b", PreviousNode: "a", Services: []string{"default/svc"}},
+		},
+		{
+			name:        "retry",
+			node:        "b",
+			status:      http.StatusNoContent,
+			wantChanged: true,
+			wantPayload: &externalPayload{IP: "192.0.2.1", Node: "b", PreviousNode: "a", Services: []string{"default/svc"}},
+		},
+	}
+
+	for _, step := range steps {
+		payloads = nil
+		status = step.status
+
+		changed, err := b.attach(context.Background(), ip, attachment{node: step.node, svc: svc})
+		if (err != nil) != step.wantErr {
+			t.Errorf("%s: got error %v, want error %t", step.name, err, step.wantErr)
+		}
+		if changed != step.wantChanged {
+			t.Errorf("%s: got changed %t, want %t", step.name, changed, step.wantChanged)
+		}
+
+		var wantPayloads []externalPayload
+		if step.wantPayload != nil {
+			wantPayloads = []externalPayload{*step.wantPayload}
+		}
+		if !reflect.DeepEqual(payloads, wantPayloads) {
+			t.Errorf("%s: got payloads %+v, want %+v", step.name, payloads, wantPayloads)
+		}
+	}
+}
+
+func TestExternalBackendHandles(t *testing.T) {
+	ranges, err := ParseIPRanges([]string{"192.0.2.0/24", " ", "2001:db8::/32"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		ranges []*net.IPNet
+		ip     string
+		want   bool
+	}{
+		{"no ranges", nil, "198.51.100.1", true},
+		{"IPv4 in range", ranges, "192.0.2.1", true},
+		{"IPv6 in range", ranges, "2001:db8::1", true},
+		{"out of range", ranges, "198.51.100.1", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newExternalBackend("true", "", tt.ranges, time.Second)
+			if got := b.handles(net.ParseIP(tt.ip)); got != tt.want {
+				t.Errorf("got %t, want %t", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := ParseIPRanges([]string{"192.0.2.0"}); err == nil {
+		t.Error("expected error for invalid range")
+	}
+}
@@ -2,79 +2,344 @@ package fipcontroller
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/hetznercloud/hcloud-go/hcloud"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/sirupsen/logrus"
-	"golang.org/x/sync/singleflight"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
 
 	"github.com/costela/hcloud-ip-floater/internal/config"
+	"github.com/costela/hcloud-ip-floater/internal/metrics"
 	"github.com/costela/hcloud-ip-floater/internal/stringset"
 )
 
+// startupRetryInterval is used instead of the sync interval until the first hcloud sync succeeded
+const startupRetryInterval = 5 * time.Second
+
 type Controller struct {
 	logger       logrus.FieldLogger
 	hcloudClient hcloudClienter
+	k8s          kubernetes.Interface
+	dynamic      dynamic.Interface
+	recorder     record.EventRecorder
 
-	attachments map[string]string
+	attachments map[string]attachment
 	attMu       sync.RWMutex
 
 	fips   map[string]*hcloud.FloatingIP
 	fipsMu sync.RWMutex
 
-	sf singleflight.Group
+	// backends for service IPs which are not floating IPs
+	backends []backend
+
+	// names of backends which synced successfully at least once; until then, their IPs are unknown
+	syncedBackends   stringset.StringSet
+	syncedBackendsMu sync.RWMutex
+
+	moves   map[string]time.Time // time of the last successful move per FIP
+	failed  stringset.StringSet  // FIPs whose last attachment failed
+	movesMu sync.Mutex
+
+	// level-triggered reconciliation: any request marks the state dirty and is served by the next run
+	dirty       chan struct{}
+	pending     *Reconciliation
+	reconcileMu sync.Mutex
+
+	// leading is set while this replica holds the leader election lease; only the leader may assign FIPs
+	leading atomic.Bool
+
+	// liveness/readiness bookkeeping
+	synced        atomic.Bool  // hcloud FIPs completely fetched at least once
+	lastPoll      atomic.Int64 // unix nanos of the last finished poll loop iteration
+	reconcileStep atomic.Int64 // unix nanos of the start of the running reconciliation or its current attachment; 0 if none
+}
+
+// attachment is the desired state of a single service IP
+type attachment struct {
+	node        string
+	server      serverRef
+	robotServer int             // Hetzner Robot server number, if the node is a dedicated server
+	svc         *corev1.Service // owner of the IP; target for events
+}
+
+// Reconciliation is a requested reconciliation run, whose result can be waited on
+type Reconciliation struct {
+	done chan struct{}
+	errs map[string]error // by FIP
+}
+
+// Done is closed once the reconciliation has finished
+func (r *Reconciliation) Done() <-chan struct{} {
+	return r.done
 }
 
-func New(logger logrus.FieldLogger, hcc *hcloud.Client) *Controller {
+// Wait blocks until the reconciliation has finished and returns the errors of all FIPs which could not be attached
+func (r *Reconciliation) Wait() map[string]error {
+	<-r.done
+	return r.errs
+}
+
+var (
+	errNotLeading  = errors.New("not the current leader")
+	errFIPNotFound = errors.New("could not find floating IP")
+
+	errBackendsNotSynced = errors.New("could not find IP while not all backends are synced")
+)
+
+func New(logger logrus.FieldLogger, hcc *hcloud.Client, k8s kubernetes.Interface, dyn dynamic.Interface, recorder record.EventRecorder) *Controller {
 	fc := &Controller{
 		logger:       logger.WithField("component", "fipcontroller"),
 		hcloudClient: hcloudClient{hcc}, // wrap in mock-helper
-		attachments:  make(map[string]string),
+		k8s:          k8s,
+		dynamic:      dyn,
+		recorder:     recorder,
+		attachments:  make(map[string]attachment),
 		fips:         make(map[string]*hcloud.FloatingIP),
+		moves:        make(map[string]time.Time),
+		failed:       make(stringset.StringSet),
+		dirty:        make(chan struct{}, 1),
+
+		syncedBackends: make(stringset.StringSet),
+	}
+
+	// routes may cover IPs outside the subnets of the alias IP network, so they take precedence
+	if config.Global.RouteNetwork != "" {
+		fc.backends = append(fc.backends, &routeBackend{
+			networkCache: networkCache{hcloudClient: fc.hcloudClient, networkRef: config.Global.RouteNetwork},
+		})
+	}
+	if config.Global.AliasIPNetwork != "" {
+		fc.backends = append(fc.backends, &aliasIPBackend{
+			networkCache: networkCache{hcloudClient: fc.hcloudClient, networkRef: config.Global.AliasIPNetwork},
+		})
+	}
+	if config.Global.RobotUser != "" {
+		fc.backends = append(fc.backends, &robotBackend{
+			robotClient: newRobotClient(config.Global.RobotUser, config.Global.RobotPassword),
+		})
+	}
+	// without ranges, the external backend takes all remaining IPs, so it must come last
+	if config.Global.ExternalCommand != "" || config.Global.ExternalWebhook != "" {
+		ranges, _ := ParseIPRanges(config.Global.ExternalIPRanges) // validated on startup
+		fc.backends = append(fc.backends, newExternalBackend(
+			config.Global.ExternalCommand,
+			config.Global.ExternalWebhook,
+			ranges,
+			time.Duration(config.Global.ExternalTimeoutSeconds)*time.Second,
+		))
 	}
 
 	return fc
 }
 
-func (fc *Controller) Run() {
-	for {
-		<-time.NewTicker(time.Duration(config.Global.SyncSeconds) * time.Second).C
+// SetLeading informs the controller whether it is currently allowed to make changes to FIP assignments.
+func (fc *Controller) SetLeading(leading bool) {
+	fc.leading.Store(leading)
+
+	if leading {
+		metrics.Leader.Set(1)
+	} else {
+		metrics.Leader.Set(0)
+	}
+}
+
+// Run polls hcloud and, while leading, reconciles FIP assignments until ctx is done. The first poll happens immediately and is retried
+// quickly until it succeeds, since services are only handled once FIPs are known. It only returns once a running
+// reconciliation has finished.
+func (fc *Controller) Run(ctx context.Context) {
+	fc.lastPoll.Store(time.Now().UnixNano())
+
+	reconcilerDone := make(chan struct{})
+	go func() {
+		defer close(reconcilerDone)
+		fc.reconcileLoop(ctx)
+	}()
 
-		if changed, err := fc.syncFloatingIPs(); err != nil {
+	for {
+		if changed, err := fc.syncFloatingIPs(ctx); err != nil {
 			fc.logger.WithError(err).Error("could not sync floating IPs")
-		} else if changed {
+		} else if changed && fc.Leading() {
 			fc.logger.Info("floating IPs changed")
 			fc.Reconcile()
 		}
+
+		if err := fc.syncMetalLB(ctx); err != nil {
+			fc.logger.WithError(err).Error("could not sync MetalLB config")
+		}
+
+		fc.lastPoll.Store(time.Now().UnixNano())
+
+		interval := time.Duration(config.Global.SyncSeconds) * time.Second
+		if !fc.Synced() {
+			interval = startupRetryInterval
+		}
+
+		timer := time.NewTimer(interval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			<-reconcilerDone
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// Leading returns whether the controller is currently allowed to make changes to FIP assignments.
+func (fc *Controller) Leading() bool {
+	return fc.leading.Load()
+}
+
+// Healthy returns an error if the hcloud poll loop or a reconciliation has been stuck for longer than deadline.
+func (fc *Controller) Healthy(deadline time.Duration) error {
+	// reconciliations attach one IP after the other, so only a single step must finish within the deadline
+	if step := fc.reconcileStep.Load(); step != 0 {
+		if stuck := time.Since(time.Unix(0, step)); stuck > deadline {
+			return fmt.Errorf("reconciliation stuck for %s", stuck.Round(time.Second))
+		}
+	}
+
+	// not running (yet), e.g. while on leader election standby
+	if last := fc.lastPoll.Load(); last != 0 {
+		interval := time.Duration(config.Global.SyncSeconds) * time.Second
+		if stuck := time.Since(time.Unix(0, last)); stuck > interval+deadline {
+			return fmt.Errorf("hcloud poll loop stuck for %s", (stuck - interval).Round(time.Second))
+		}
 	}
 
+	return nil
+}
+
+// Synced returns whether floating IPs have been completely fetched from hcloud at least once. Backends are tracked
+// separately (see BackendsSynced), so a failing backend doesn't hold back failovers of floating IPs.
+func (fc *Controller) Synced() bool {
+	return fc.synced.Load()
+}
+
+// BackendsSynced returns whether all backends have synced successfully at least once, i.e. all IPs are known.
+func (fc *Controller) BackendsSynced() bool {
+	fc.syncedBackendsMu.RLock()
+	defer fc.syncedBackendsMu.RUnlock()
+
+	for _, b := range fc.backends {
+		if !fc.syncedBackends.Has(b.name()) {
+			return false
+		}
+	}
+	return true
+}
+
+func (fc *Controller) backendSynced(b backend) bool {
+	fc.syncedBackendsMu.RLock()
+	defer fc.syncedBackendsMu.RUnlock()
+
+	return fc.syncedBackends.Has(b.name())
+}
+
+// FloatingIP is a read-only view of a floating IP known to the controller
+type FloatingIP struct {
+	IP   string // address to be used by services; the first host address for IPv6 networks
+	Name string
+}
+
+// FloatingIPs returns all floating IPs found during the last sync with hcloud and the other providers, ordered by IP
+func (fc *Controller) FloatingIPs() []FloatingIP {
+	fc.fipsMu.RLock()
+	defer fc.fipsMu.RUnlock()
+
+	fips := make([]FloatingIP, 0, len(fc.fips))
+	for ip, fip := range fc.fips {
+		fips = append(fips, FloatingIP{IP: hostAddress(ip), Name: fip.Name})
+	}
+	for _, b := range fc.backends {
+		if p, ok := b.(provider); ok {
+			for _, fip := range p.floatingIPs() {
+				fip.IP = hostAddress(fip.IP)
+				fips = append(fips, fip)
+			}
+		}
+	}
+
+	sort.Slice(fips, func(i, j int) bool {
+		return fips[i].IP < fips[j].IP
+	})
+
+	return fips
+}
+
+// hostAddress turns the base address of an IPv6 network into its first host address (::1), which can actually be
+// used by a service. Other addresses are returned as is.
+func hostAddress(ip string) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil || parsed.To4() != nil {
+		return ip
+	}
+
+	for _, b := range parsed[net.IPv6len/2:] {
+		if b != 0 {
+			return ip
+		}
+	}
+
+	host := make(net.IP, net.IPv6len)
+	copy(host, parsed)
+	host[net.IPv6len-1] = 1
+	return host.String()
 }
 
 // AttachToNode adds a FIP-to-node attachment to our worldview and immediately attempts to reconcile it with hcloud's
-func (fc *Controller) AttachToNode(svcIPs stringset.StringSet, node string) {
+func (fc *Controller) AttachToNode(ctx context.Context, svc *corev1.Service, svcIPs stringset.StringSet, node *corev1.Node) error {
+	server, err := serverRefForNode(node)
+	robotServer, isRobot := robotServerForNode(node)
+	if err != nil && !isRobot {
+		return err
+	}
+
 	fc.attMu.Lock()
 
 	var changedAttachment bool
 	for ip := range svcIPs {
-		if oldNode, found := fc.attachments[ip]; !found || node != oldNode {
-			fc.attachments[ip] = node
+		oldAtt, found := fc.attachments[ip]
+		fc.attachments[ip] = attachment{node: node.Name, server: server, robotServer: robotServer, svc: svc}
+
+		if !found || node.Name != oldAtt.node || server != oldAtt.server || robotServer != oldAtt.robotServer {
 			changedAttachment = true
+
+			metrics.FIPDesiredNode.DeletePartialMatch(prometheus.Labels{"fip": ip})
+			metrics.FIPDesiredNode.WithLabelValues(ip, node.Name).Set(1)
 		}
 	}
 
 	// this cannot be deferred since syncFloatingIPs does it's own locking
 	fc.attMu.Unlock()
 
-	if changedAttachment {
-		_, err := fc.syncFloatingIPs()
+	// unchanged attachments are retried if they failed before
+	if changedAttachment || fc.attachFailed(svcIPs) {
+		_, err := fc.syncFloatingIPs(ctx)
 		if err != nil {
-			fc.logger.WithError(err).Error("could not fetch FIPs")
-			return
+			return fmt.Errorf("could not fetch FIPs: %w", err)
+		}
+
+		r := fc.Reconcile()
+		select {
+		case <-r.Done():
+		case <-ctx.Done():
+			return ctx.Err()
 		}
-		fc.Reconcile()
+		return fc.serviceErrors(svcIPs, r.Wait())
 	}
+
+	return nil
 }
 
 // ForgetAttachments remove the desired attachment from our worldview. This avoids "stealing" stale attachments from
@@ -86,17 +351,38 @@ func (fc *Controller) ForgetAttachments(svcIPs stringset.StringSet) {
 
 	for ip := range svcIPs {
 		delete(fc.attachments, ip)
+		metrics.FIPDesiredNode.DeletePartialMatch(prometheus.Labels{"fip": ip})
 	}
 }
 
-func (fc *Controller) syncFloatingIPs() (bool, error) {
-	fips, err := fc.hcloudClient.FloatingIP().AllWithOpts(context.Background(), hcloud.FloatingIPListOpts{
-		ListOpts: hcloud.ListOpts{
-			LabelSelector: config.Global.FloatingLabelSelector,
-		},
-	})
-	if err != nil {
-		return false, err
+func (fc *Controller) syncFloatingIPs(ctx context.Context) (bool, error) {
+	var fips []*hcloud.FloatingIP
+	// without token, only other providers are used
+	if config.Global.HCloudToken != "" {
+		listCtx, cancel := APIContext(ctx)
+		var err error
+		fips, err = fc.hcloudClient.FloatingIP().AllWithOpts(listCtx, hcloud.FloatingIPListOpts{
+			ListOpts: hcloud.ListOpts{
+				LabelSelector: config.Global.FloatingLabelSelector,
+			},
+		})
+		cancel()
+		if err != nil {
+			return false, err
+		}
+	}
+
+	// services are only handled once we know all FIPs, so we only count as synced if nothing failed
+	complete := true
+
+	for _, b := range fc.backends {
+		if err := b.sync(ctx); err != nil {
+			fc.logger.WithError(err).WithField("backend", b.name()).Error("could not sync backend")
+			continue
+		}
+		fc.syncedBackendsMu.Lock()
+		fc.syncedBackends.Add(b.name())
+		fc.syncedBackendsMu.Unlock()
 	}
 
 	fc.fipsMu.Lock()
@@ -116,11 +402,14 @@ func (fc *Controller) syncFloatingIPs() (bool, error) {
 			// resolve Server reference (API returns only empty struct with ID)
 			// TODO: can we safely cache server info? Can we even support name changes?
 			if fip.Server != nil {
-				srv, _, err := fc.hcloudClient.Server().GetByID(context.Background(), fip.Server.ID)
+				getCtx, cancel := APIContext(ctx)
+				srv, _, err := fc.hcloudClient.Server().GetByID(getCtx, fip.Server.ID)
+				cancel()
 				if err != nil {
 					fc.logger.WithError(err).WithFields(logrus.Fields{
 						"server_id": fip.Server.ID,
 					}).Error("could not find server")
+					complete = false
 					continue
 				}
 				fip.Server = srv
@@ -128,9 +417,12 @@ func (fc *Controller) syncFloatingIPs() (bool, error) {
 
 			fc.fips[ip] = fip
 			changedFIPs = true
-		} else if attachment, _ := fc.getAttachment(ip); fipServerName(oldFIP) != attachment {
-			// FIP hasn't changed but attachment doesn't match so let's reconcile
-			changedFIPs = true
+
+			metrics.FIPServer.DeletePartialMatch(prometheus.Labels{"fip": ip})
+			metrics.FIPServer.WithLabelValues(ip, fipServerName(fip)).Set(1)
+		} else {
+			// labels are not relevant for attachments, but are used for grouping
+			oldFIP.Labels = fip.Labels
 		}
 	}
 
@@ -138,64 +430,249 @@ func (fc *Controller) syncFloatingIPs() (bool, error) {
 		if !seenFIPs.Has(fip) {
 			delete(fc.fips, fip)
 			changedFIPs = true
+
+			metrics.FIPServer.DeletePartialMatch(prometheus.Labels{"fip": fip})
 		}
 	}
 
+	for ip, att := range fc.desiredAttachmentsLocked() {
+		if !att.server.matches(fc.fips[ip].Server) {
+			// FIP hasn't changed but attachment doesn't match so let's reconcile
+			changedFIPs = true
+		}
+	}
+
+	// we don't track the state of backend IPs, so always let the backends check them
+	for ip := range fc.getServiceIPs() {
+		if _, _, found := fc.fipForIPLocked(ip); !found && fc.backendFor(ip) != nil {
+			changedFIPs = true
+			break
+		}
+	}
+
+	if complete {
+		fc.synced.Store(true)
+	}
+
 	return changedFIPs, nil
 }
 
-// Reconcile starts an asynchronous attempt to make the managed floating IPs match the controller's worldview about
-// which attachments should be current.
-func (fc *Controller) Reconcile() {
-	_ = fc.sf.DoChan("reconciliation", func() (interface{}, error) {
-		fc.logger.Info("starting reconciliation")
-
-		toAttach := fc.getServiceIPs()
-
-		fc.fipsMu.RLock()
-		defer fc.fipsMu.RUnlock()
-
-		for ip, fip := range fc.fips {
-			node, found := fc.getAttachment(ip)
-			if !found {
-				// FIP not known to us; ignore
-				fc.logger.WithFields(logrus.Fields{
-					"fip": ip,
-				}).Debug("ignoring unattached floating IP")
-				continue
-			}
+// Reconcile requests an attempt to make the managed floating IPs match the controller's worldview about which
+// attachments should be current. Requests made while a reconciliation is running are never lost: they all share
+// exactly one follow-up run, whose result is returned.
+func (fc *Controller) Reconcile() *Reconciliation {
+	fc.reconcileMu.Lock()
+	if fc.pending == nil {
+		fc.pending = &Reconciliation{done: make(chan struct{})}
+	}
+	r := fc.pending
+	fc.reconcileMu.Unlock()
 
-			if fipServerName(fip) != node {
-				err := fc.attachFIPToNode(fip, node)
-				if err != nil {
-					fc.logger.WithError(err).WithFields(logrus.Fields{
-						"fip":  ip,
-						"node": node,
-					}).Error("could not attach floating IP")
-				} else {
-					fc.logger.WithFields(logrus.Fields{
-						"fip":  ip,
-						"node": node,
-					}).Info("attached floating IP")
-				}
-			} else {
-				fc.logger.WithFields(logrus.Fields{
-					"fip":  ip,
-					"node": node,
-				}).Info("floating IP already attached")
-			}
-			delete(toAttach, ip)
+	select {
+	case fc.dirty <- struct{}{}:
+	default: // a run is already scheduled
+	}
+
+	return r
+}
+
+// reconcileLoop runs reconciliations whenever they were requested since the last run started, until ctx is done
+func (fc *Controller) reconcileLoop(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-fc.dirty:
+		}
+
+		fc.reconcileMu.Lock()
+		r := fc.pending
+		fc.pending = nil
+		fc.reconcileMu.Unlock()
+
+		if r == nil {
+			continue
+		}
+
+		r.errs = fc.reconcile(ctx)
+		close(r.done)
+	}
+}
+
+// reconcile attaches all FIPs according to the desired attachments, returning errors by FIP
+func (fc *Controller) reconcile(ctx context.Context) map[string]error {
+	fc.logger.Info("starting reconciliation")
+
+	start := time.Now()
+	fc.reconcileStep.Store(start.UnixNano())
+
+	errs := make(map[string]error)
+	var failed bool
+	defer func() {
+		fc.reconcileStep.Store(0)
+		metrics.Reconciliations.Inc()
+		metrics.ReconciliationDuration.Observe(time.Since(start).Seconds())
+		if failed {
+			metrics.ReconciliationFailures.Inc()
+		}
+	}()
+
+	toAttach := fc.getServiceIPs()
+
+	// assignments can take a long time, so only snapshot what to do under the lock; holding it would block
+	// syncFloatingIPs and, behind it, everyone reading FIPs (e.g. the service event handlers)
+	type fipAssignment struct {
+		ip  string
+		fip *hcloud.FloatingIP
+		att attachment
+	}
+	var assignments []fipAssignment
+
+	fc.fipsMu.RLock()
+	desired := fc.desiredAttachmentsLocked()
+
+	for svcIP := range toAttach {
+		if _, _, found := fc.fipForIPLocked(svcIP); found {
+			delete(toAttach, svcIP)
 		}
-		for ip := range toAttach {
+	}
+
+	for ip, fip := range fc.fips {
+		att, found := desired[ip]
+		if !found {
+			// FIP not known to us; ignore
 			fc.logger.WithFields(logrus.Fields{
 				"fip": ip,
-			}).Warn("could not find floating IP")
+			}).Debug("ignoring unattached floating IP")
+			continue
 		}
 
-		fc.logger.Info("reconciliation done")
+		if !att.server.matches(fip.Server) {
+			assignments = append(assignments, fipAssignment{ip: ip, fip: fip, att: att})
+		} else {
+			fc.logger.WithFields(logrus.Fields{
+				"fip":  ip,
+				"node": att.node,
+			}).Info("floating IP already attached")
+		}
+	}
+	fc.fipsMu.RUnlock()
 
-		return nil, nil
-	})
+	for _, a := range assignments {
+		// don't start new assignments when shutting down
+		if ctx.Err() != nil {
+			errs[a.ip] = ctx.Err()
+			continue
+		}
+
+		assignStart := time.Now()
+		fc.reconcileStep.Store(assignStart.UnixNano())
+		attachCtx, cancel := attachContext(ctx)
+		err := fc.attachFIPToNode(attachCtx, a.fip, a.att)
+		cancel()
+		metrics.AssignDuration.Observe(time.Since(assignStart).Seconds())
+		if err != nil {
+			errs[a.ip] = err
+			failed = true
+		}
+		fc.reportAttachment(a.ip, a.att, err)
+	}
+	for ip := range toAttach {
+		b := fc.backendFor(ip)
+		if b == nil {
+			continue
+		}
+		delete(toAttach, ip)
+
+		att, found := fc.getAttachment(ip)
+		if !found {
+			continue
+		}
+
+		if !fc.Leading() {
+			fc.reportAttachment(ip, att, errNotLeading)
+			errs[ip] = errNotLeading
+			failed = true
+			continue
+		}
+
+		if ctx.Err() != nil {
+			errs[ip] = ctx.Err()
+			continue
+		}
+
+		// backends check the current state themselves, so we only know whether something changed afterwards
+		assignStart := time.Now()
+		fc.reconcileStep.Store(assignStart.UnixNano())
+		attachCtx, cancel := attachContext(ctx)
+		changed, err := b.attach(attachCtx, net.ParseIP(ip), att)
+		cancel()
+		if err != nil {
+			errs[ip] = err
+			failed = true
+		}
+		if changed || err != nil {
+			metrics.AssignDuration.Observe(time.Since(assignStart).Seconds())
+			fc.reportAttachment(ip, att, err)
+		} else {
+			fc.logger.WithFields(logrus.Fields{
+				"fip":     ip,
+				"node":    att.node,
+				"backend": b.name(),
+			}).Info("floating IP already attached")
+		}
+	}
+	for ip := range toAttach {
+		if !fc.BackendsSynced() {
+			// may belong to a backend we couldn't sync yet; retried once it synced
+			errs[ip] = errBackendsNotSynced
+			fc.logger.WithFields(logrus.Fields{
+				"fip": ip,
+			}).Info("floating IP not found while backends are not synced")
+			continue
+		}
+
+		// not a failure of the reconciliation itself, but the service's IP is not attached either
+		errs[ip] = errFIPNotFound
+		fc.logger.WithFields(logrus.Fields{
+			"fip": ip,
+		}).Warn("could not find floating IP")
+		if att, found := fc.getAttachment(ip); found {
+			fc.recorder.Eventf(att.svc, corev1.EventTypeWarning, "FloatingIPNotFound", "could not find floating IP %s in hcloud", ip)
+		}
+	}
+
+	fc.logger.Info("reconciliation done")
+
+	return errs
+}
+
+// reportAttachment logs, counts and records events for the result of an attachment attempt
+func (fc *Controller) reportAttachment(ip string, att attachment, err error) {
+	fc.movesMu.Lock()
+	if err != nil {
+		fc.failed.Add(ip)
+	} else {
+		delete(fc.failed, ip)
+		fc.moves[ip] = time.Now()
+	}
+	fc.movesMu.Unlock()
+
+	if err != nil {
+		metrics.AssignErrors.WithLabelValues(ip).Inc()
+		fc.logger.WithError(err).WithFields(logrus.Fields{
+			"fip":  ip,
+			"node": att.node,
+		}).Error("could not attach floating IP")
+		fc.recorder.Eventf(att.svc, corev1.EventTypeWarning, "FloatingIPAttachFailed", "could not attach floating IP %s to node %s: %s", ip, att.node, err)
+		return
+	}
+
+	fc.logger.WithFields(logrus.Fields{
+		"fip":  ip,
+		"node": att.node,
+	}).Info("attached floating IP")
+	fc.recorder.Eventf(att.svc, corev1.EventTypeNormal, "FloatingIPAttached", "attached floating IP %s to node %s", ip, att.node)
 }
 
 func (fc *Controller) getServiceIPs() stringset.StringSet {
@@ -210,32 +687,31 @@ func (fc *Controller) getServiceIPs() stringset.StringSet {
 	return ips
 }
 
-func (fc *Controller) getAttachment(ip string) (string, bool) {
+func (fc *Controller) getAttachment(ip string) (attachment, bool) {
 	fc.attMu.RLock()
-	node, found := fc.attachments[ip]
+	att, found := fc.attachments[ip]
 	fc.attMu.RUnlock()
 
-	return node, found
+	return att, found
 }
 
-func (fc *Controller) attachFIPToNode(fip *hcloud.FloatingIP, node string) error {
-	server, _, err := fc.hcloudClient.Server().GetByName(context.Background(), node)
-	if err != nil {
-		return err
+func (fc *Controller) attachFIPToNode(ctx context.Context, fip *hcloud.FloatingIP, att attachment) error {
+	// a replica that lost the lease must not fight the new leader over assignments
+	if !fc.Leading() {
+		return errNotLeading
 	}
 
-	// extra safety for https://github.com/costela/hcloud-ip-floater/issues/8
-	if server == nil {
-		return fmt.Errorf("could not find node %s", node)
+	server, err := getServer(ctx, fc.hcloudClient, att)
+	if err != nil {
+		return err
 	}
 
-	act, _, err := fc.hcloudClient.FloatingIP().Assign(context.Background(), fip, server)
+	act, _, err := fc.hcloudClient.FloatingIP().Assign(ctx, fip, server)
 	if err != nil {
 		return err
 	}
 
-	_, errc := fc.hcloudClient.Action().WatchProgress(context.Background(), act)
-	return <-errc
+	return waitForAction(ctx, fc.hcloudClient, act)
 }
 
 func fipEquals(oldFIP *hcloud.FloatingIP, newFIP *hcloud.FloatingIP) bool {
@@ -0,0 +1,255 @@
+package fipcontroller
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/hetznercloud/hcloud-go/hcloud"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/costela/hcloud-ip-floater/internal/config"
+	"github.com/costela/hcloud-ip-floater/internal/stringset"
+)
+
+func TestHostAddress(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want string
+	}{
+		{"192.0.2.1", "192.0.2.1"},
+		{"192.0.2.0", "192.0.2.0"},
+		{"2001:db8:1::", "2001:db8:1::1"},
+		{"2001:db8:1:2::", "2001:db8:1:2::1"},
+		{"2001:db8:1::5", "2001:db8:1::5"},
+		{"2001:db8:1::1:0", "2001:db8:1::1:0"},
+		{"::ffff:192.0.2.1", "::ffff:192.0.2.1"},
+		{"foo", "foo"},
+	}
+
+	for _, tt := range tests {
+		if got := hostAddress(tt.ip); got != tt.want {
+			t.Errorf("hostAddress(%q) = %q, want %q", tt.ip, got, tt.want)
+		}
+	}
+}
+
+// mockHcloudClient blocks every FIP assignment until it is released, so tests control when reconciliations finish
+type mockHcloudClient struct {
+	assigning chan string   // receives the IP of each assignment as it starts
+	release   chan struct{} // lets one assignment finish
+}
+
+func (m *mockHcloudClient) FloatingIP() hcloudFloatingIPer { return m }
+func (m *mockHcloudClient) Server() hcloudServerer         { return m }
+func (m *mockHcloudClient) Action() hcloudActioner         { return m }
+func (m *mockHcloudClient) Network() hcloudNetworker       { return nil }
+
+func (m *mockHcloudClient) AllWithOpts(context.Context, hcloud.FloatingIPListOpts) ([]*hcloud.FloatingIP, error) {
+	return nil, nil
+}
+
+func (m *mockHcloudClient) Assign(ctx context.Context, fip *hcloud.FloatingIP, _ *hcloud.Server) (*hcloud.Action, *hcloud.Response, error) {
+	m.assigning <- fip.IP.String()
+	select {
+	case <-m.release:
+	case <-ctx.Done():
+		return nil, nil, ctx.Err()
+	}
+	return &hcloud.Action{ID: 1}, nil, nil
+}
+
+func (m *mockHcloudClient) GetByID(_ context.Context, id int) (*hcloud.Server, *hcloud.Response, error) {
+	return &hcloud.Server{ID: id}, nil, nil
+}
+
+func (m *mockHcloudClient) GetByName(_ context.Context, name string) (*hcloud.Server, *hcloud.Response, error) {
+	return &hcloud.Server{Name: name}, nil, nil
+}
+
+func (m *mockHcloudClient) All(context.Context) ([]*hcloud.Server, error) {
+	return nil, nil
+}
+
+func (m *mockHcloudClient) ChangeAliasIPs(context.Context, *hcloud.Server, hcloud.ServerChangeAliasIPsOpts) (*hcloud.Action, *hcloud.Response, error) {
+	return nil, nil, nil
+}
+
+func (m *mockHcloudClient) WatchProgress(context.Context, *hcloud.Action) (<-chan int, <-chan error) {
+	progress := make(chan int)
+	errc := make(chan error, 1)
+	close(progress)
+	errc <- nil
+	return progress, errc
+}
+
+func newReconcileTestController(t *testing.T, hcc hcloudClienter) *Controller {
+	saved := config.Global
+	t.Cleanup(func() { config.Global = saved })
+	config.Global.AttachTimeoutSeconds = 10
+	config.Global.ShutdownGraceSeconds = 0
+
+	svc := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "svc"}}
+
+	fc := &Controller{
+		logger:       newTestLogger(),
+		hcloudClient: hcc,
+		recorder:     record.NewFakeRecorder(100),
+		attachments: map[string]attachment{
+			// never attached, since the mock doesn't update the FIP
+			"192.0.2.1": {node: "node-a", server: serverRef{id: 42}, svc: svc},
+		},
+		fips: map[string]*hcloud.FloatingIP{
+			"192.0.2.1": {ID: 1, Type: hcloud.FloatingIPTypeIPv4, IP: net.ParseIP("192.0.2.1")},
+		},
+		moves:  make(map[string]time.Time),
+		failed: make(stringset.StringSet),
+		dirty:  make(chan struct{}, 1),
+	}
+	fc.leading.Store(true)
+
+	return fc
+}
+
+func TestReconcileCoalescing(t *testing.T) {
+	const timeout = 5 * time.Second
+
+	expectAssignment := func(t *testing.T, hcc *mockHcloudClient) {
+		t.Helper()
+		select {
+		case <-hcc.assigning:
+		case <-time.After(timeout):
+			t.Fatal("timed out waiting for assignment")
+		}
+	}
+
+	expectNoAssignment := func(t *testing.T, hcc *mockHcloudClient) {
+		t.Helper()
+		select {
+		case ip := <-hcc.assigning:
+			t.Fatalf("unexpected assignment of %s", ip)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+
+	expectDone := func(t *testing.T, r *Reconciliation) {
+		t.Helper()
+		select {
+		case <-r.Done():
+		case <-time.After(timeout):
+			t.Fatal("timed out waiting for reconciliation")
+		}
+		if errs := r.Wait(); len(errs) != 0 {
+			t.Errorf("unexpected errors: %v", errs)
+		}
+	}
+
+	expectRunning := func(t *testing.T, r *Reconciliation) {
+		t.Helper()
+		select {
+		case <-r.Done():
+			t.Fatal("reconciliation finished too early")
+		default:
+		}
+	}
+
+	tests := []struct {
+		name string
+		run  func(t *testing.T, fc *Controller, hcc *mockHcloudClient, start func())
+	}{
+		{
+			name: "requests before the loop share one run",
+			run: func(t *testing.T, fc *Controller, hcc *mockHcloudClient, start func()) {
+				first := fc.Reconcile()
+				second := fc.Reconcile()
+				if first != second {
+					t.Fatal("requests did not share a reconciliation")
+				}
+
+				start()
+
+				expectAssignment(t, hcc)
+				hcc.release <- struct{}{}
+				expectDone(t, first)
+				expectNoAssignment(t, hcc)
+			},
+		},
+		{
+			name: "requests during a run share one follow-up run",
+			run: func(t *testing.T, fc *Controller, hcc *mockHcloudClient, start func()) {
+				start()
+
+				first := fc.Reconcile()
+				expectAssignment(t, hcc)
+
+				second := fc.Reconcile()
+				third := fc.Reconcile()
+				if second == first {
+					t.Fatal("request during a run was served by the running reconciliation")
+				}
+				if second != third {
+					t.Fatal("requests during a run did not share a follow-up reconciliation")
+				}
+				expectRunning(t, first)
+
+				hcc.release <- struct{}{}
+				expectDone(t, first)
+
+				expectAssignment(t, hcc)
+				expectRunning(t, second)
+				hcc.release <- struct{}{}
+				expectDone(t, second)
+				expectNoAssignment(t, hcc)
+			},
+		},
+		{
+			name: "requests after a run get a new run",
+			run: func(t *testing.T, fc *Controller, hcc *mockHcloudClient, start func()) {
+				start()
+
+				first := fc.Reconcile()
+				expectAssignment(t, hcc)
+				hcc.release <- struct{}{}
+				expectDone(t, first)
+
+				second := fc.Reconcile()
+				if second == first {
+					t.Fatal("request after a run was served by the finished reconciliation")
+				}
+				expectAssignment(t, hcc)
+				hcc.release <- struct{}{}
+				expectDone(t, second)
+				expectNoAssignment(t, hcc)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hcc := &mockHcloudClient{
+				assigning: make(chan string),
+				release:   make(chan struct{}),
+			}
+			fc := newReconcileTestController(t, hcc)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			loopDone := make(chan struct{})
+			defer func() {
+				cancel()
+				<-loopDone
+			}()
+
+			start := func() {
+				go func() {
+					defer close(loopDone)
+					fc.reconcileLoop(ctx)
+				}()
+			}
+
+			tt.run(t, fc, hcc, start)
+		})
+	}
+}
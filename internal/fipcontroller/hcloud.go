@@ -11,6 +11,7 @@ type hcloudClienter interface {
 	FloatingIP() hcloudFloatingIPer
 	Server() hcloudServerer
 	Action() hcloudActioner
+	Network() hcloudNetworker
 }
 
 type hcloudClient struct {
@@ -29,6 +30,10 @@ func (hcc hcloudClient) Action() hcloudActioner {
 	return &hcc.Client.Action
 }
 
+func (hcc hcloudClient) Network() hcloudNetworker {
+	return &hcc.Client.Network
+}
+
 type hcloudFloatingIPer interface {
 	AllWithOpts(context.Context, hcloud.FloatingIPListOpts) ([]*hcloud.FloatingIP, error)
 	Assign(context.Context, *hcloud.FloatingIP, *hcloud.Server) (*hcloud.Action, *hcloud.Response, error)
@@ -37,8 +42,16 @@ type hcloudFloatingIPer interface {
 type hcloudServerer interface {
 	GetByID(context.Context, int) (*hcloud.Server, *hcloud.Response, error)
 	GetByName(context.Context, string) (*hcloud.Server, *hcloud.Response, error)
+	All(context.Context) ([]*hcloud.Server, error)
+	ChangeAliasIPs(context.Context, *hcloud.Server, hcloud.ServerChangeAliasIPsOpts) (*hcloud.Action, *hcloud.Response, error)
 }
 
 type hcloudActioner interface {
 	WatchProgress(context.Context, *hcloud.Action) (<-chan int, <-chan error)
 }
+
+type hcloudNetworker interface {
+	Get(context.Context, string) (*hcloud.Network, *hcloud.Response, error)
+	AddRoute(context.Context, *hcloud.Network, hcloud.NetworkAddRouteOpts) (*hcloud.Action, *hcloud.Response, error)
+	DeleteRoute(context.Context, *hcloud.Network, hcloud.NetworkDeleteRouteOpts) (*hcloud.Action, *hcloud.Response, error)
+}
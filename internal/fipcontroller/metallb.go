@@ -0,0 +1,226 @@
+package fipcontroller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hetznercloud/hcloud-go/hcloud"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+
+	"github.com/costela/hcloud-ip-floater/internal/config"
+	"github.com/costela/hcloud-ip-floater/internal/stringset"
+)
+
+// supported ways of configuring MetalLB
+const (
+	MetalLBModeConfigMap = "configmap" // legacy ConfigMap, up to MetalLB 0.12
+	MetalLBModeCRD       = "crd"       // IPAddressPool and L2Advertisement resources, since MetalLB 0.13
+)
+
+// defaultPoolName is used for FIPs without the pool label, or when no pool label is configured
+const defaultPoolName = "hcloud-ip-floater"
+
+// managedPoolsAnnotation records which address pools in MetalLB's ConfigMap are ours, so others are left untouched
+const managedPoolsAnnotation = "hcloud-ip-floater.cstl.dev/managed-pools"
+
+// metalLBAddressPool mirrors an address pool in MetalLB's legacy ConfigMap format
+type metalLBAddressPool struct {
+	Name       string   `json:"name"`
+	Protocol   string   `json:"protocol"`
+	Addresses  []string `json:"addresses"`
+	AutoAssign *bool    `json:"auto-assign,omitempty"`
+}
+
+// addressPool is a group of FIPs sharing the same pool label
+type addressPool struct {
+	addresses  []string
+	autoAssign bool
+}
+
+// syncMetalLB keeps MetalLB's configuration in line with the FIPs known to us, if enabled. Only the leader writes it.
+func (fc *Controller) syncMetalLB(ctx context.Context) error {
+	if config.Global.MetalLBNamespace == "" || !fc.Leading() {
+		return nil
+	}
+
+	// without a complete FIP inventory we would remove all of MetalLB's addresses
+	if config.Global.HCloudToken == "" || !fc.Synced() {
+		return nil
+	}
+
+	switch config.Global.MetalLBMode {
+	case MetalLBModeConfigMap:
+		return fc.syncMetalLBConfig(ctx)
+	case MetalLBModeCRD:
+		return fc.syncMetalLBResources(ctx)
+	}
+
+	return nil
+}
+
+// syncMetalLBConfig writes MetalLB's ConfigMap so our address pools contain exactly the FIPs known to us. The rest of
+// the config (e.g. peers or other pools) is kept as is.
+func (fc *Controller) syncMetalLBConfig(ctx context.Context) error {
+	if config.Global.MetalLBConfigName == "" {
+		return nil
+	}
+
+	ctx, cancel := APIContext(ctx)
+	defer cancel()
+
+	pools := fc.addressPools()
+
+	poolNames := make([]string, 0, len(pools))
+	for name := range pools {
+		poolNames = append(poolNames, name)
+	}
+	sort.Strings(poolNames)
+
+	configMaps := fc.k8s.CoreV1().ConfigMaps(config.Global.MetalLBNamespace)
+
+	cm, err := configMaps.Get(ctx, config.Global.MetalLBConfigName, metav1.GetOptions{})
+	missing := apierrors.IsNotFound(err)
+	if missing {
+		cm = &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      config.Global.MetalLBConfigName,
+				Namespace: config.Global.MetalLBNamespace,
+			},
+		}
+	} else if err != nil {
+		return err
+	}
+
+	cfg := make(map[string]interface{})
+	if err := yaml.Unmarshal([]byte(cm.Data["config"]), &cfg); err != nil {
+		return fmt.Errorf("could not parse MetalLB config: %w", err)
+	}
+	if cfg == nil {
+		// empty config
+		cfg = make(map[string]interface{})
+	}
+
+	previouslyManaged := make(stringset.StringSet)
+	for _, name := range strings.Split(cm.Annotations[managedPoolsAnnotation], ",") {
+		if name != "" {
+			previouslyManaged.Add(name)
+		}
+	}
+
+	addressPools := make([]interface{}, 0, len(pools))
+	if existing, ok := cfg["address-pools"].([]interface{}); ok {
+		for _, pool := range existing {
+			if pool, ok := pool.(map[string]interface{}); ok {
+				if name, _ := pool["name"].(string); previouslyManaged.Has(name) || pools[name] != nil {
+					continue
+				}
+			}
+			addressPools = append(addressPools, pool)
+		}
+	}
+
+	for _, name := range poolNames {
+		pool := metalLBAddressPool{
+			Name:      name,
+			Protocol:  "layer2",
+			Addresses: pools[name].addresses,
+		}
+		if !pools[name].autoAssign {
+			pool.AutoAssign = &pools[name].autoAssign
+		}
+		addressPools = append(addressPools, pool)
+	}
+	cfg["address-pools"] = addressPools
+
+	data, err := yaml.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+
+	managed := strings.Join(poolNames, ",")
+
+	if missing {
+		cm.Data = map[string]string{"config": string(data)}
+		cm.Annotations = map[string]string{managedPoolsAnnotation: managed}
+
+		_, err = configMaps.Create(ctx, cm, metav1.CreateOptions{})
+		if err == nil {
+			fc.logger.WithFields(logrus.Fields{
+				"namespace": config.Global.MetalLBNamespace,
+				"configmap": config.Global.MetalLBConfigName,
+			}).Info("created MetalLB config")
+		}
+		return err
+	}
+
+	if cm.Data["config"] == string(data) && cm.Annotations[managedPoolsAnnotation] == managed {
+		return nil
+	}
+
+	cm = cm.DeepCopy()
+	if cm.Data == nil {
+		cm.Data = make(map[string]string)
+	}
+	cm.Data["config"] = string(data)
+	if cm.Annotations == nil {
+		cm.Annotations = make(map[string]string)
+	}
+	cm.Annotations[managedPoolsAnnotation] = managed
+
+	if _, err := configMaps.Update(ctx, cm, metav1.UpdateOptions{}); err != nil {
+		return err
+	}
+
+	fc.logger.WithFields(logrus.Fields{
+		"namespace": config.Global.MetalLBNamespace,
+		"configmap": config.Global.MetalLBConfigName,
+	}).Info("updated MetalLB config")
+
+	return nil
+}
+
+// addressPools groups the addresses of all known FIPs by the value of their pool label. A pool is only auto-assigned
+// by MetalLB if none of its FIPs opt out via the auto-assign label.
+func (fc *Controller) addressPools() map[string]*addressPool {
+	fc.fipsMu.RLock()
+	defer fc.fipsMu.RUnlock()
+
+	pools := make(map[string]*addressPool)
+	for _, fip := range fc.fips {
+		name := defaultPoolName
+		if value := fip.Labels[config.Global.MetalLBPoolLabel]; config.Global.MetalLBPoolLabel != "" && value != "" {
+			name = value
+		}
+
+		pool, ok := pools[name]
+		if !ok {
+			pool = &addressPool{autoAssign: true}
+			pools[name] = pool
+		}
+
+		pool.addresses = append(pool.addresses, fipAddress(fip))
+		if config.Global.MetalLBAutoAssignLabel != "" && fip.Labels[config.Global.MetalLBAutoAssignLabel] == "false" {
+			pool.autoAssign = false
+		}
+	}
+
+	for _, pool := range pools {
+		sort.Strings(pool.addresses)
+	}
+
+	return pools
+}
+
+// fipAddress returns the FIP in CIDR notation; IPv6 FIPs are whole networks
+func fipAddress(fip *hcloud.FloatingIP) string {
+	if fip.Network != nil {
+		return fip.Network.String()
+	}
+	return fip.IP.String() + "/32"
+}
@@ -0,0 +1,222 @@
+package fipcontroller
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/hetznercloud/hcloud-go/hcloud"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"sigs.k8s.io/yaml"
+
+	"github.com/costela/hcloud-ip-floater/internal/config"
+)
+
+func TestSyncMetalLBConfig(t *testing.T) {
+	const (
+		namespace = "metallb-system"
+		name      = "config"
+	)
+
+	tests := []struct {
+		name        string
+		existing    *corev1.ConfigMap // nil if missing
+		wantPools   []string          // names, in order
+		wantPeers   bool
+		wantUpdated bool
+	}{
+		{
+			name:        "missing",
+			wantPools:   []string{"hcloud-ip-floater", "other"},
+			wantUpdated: true,
+		},
+		{
+			name: "empty",
+			existing: &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+			},
+			wantPools:   []string{"hcloud-ip-floater", "other"},
+			wantUpdated: true,
+		},
+		{
+			name: "foreign pools and peers are kept",
+			existing: &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+				Data: map[string]string{"config": `
+peers:
+- peer-address: 10.0.0.1
+  peer-asn: 64501
+  my-asn: 64500
+address-pools:
+- name: foreign
+  protocol: bgp
+  addresses:
+  - 198.51.100.0/24
+`},
+			},
+			wantPools:   []string{"foreign", "hcloud-ip-floater", "other"},
+			wantPeers:   true,
+			wantUpdated: true,
+		},
+		{
+			name: "previously managed pools are removed",
+			existing: &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace:   namespace,
+					Name:        name,
+					Annotations: map[string]string{managedPoolsAnnotation: "gone,hcloud-ip-floater"},
+				},
+				Data: map[string]string{"config": `
+address-pools:
+- name: gone
+  protocol: layer2
+  addresses:
+  - 192.0.2.99/32
+- name: hcloud-ip-floater
+  protocol: layer2
+  addresses:
+  - 192.0.2.99/32
+- name: foreign
+  protocol: layer2
+  addresses:
+  - 198.51.100.0/24
+`},
+			},
+			wantPools:   []string{"foreign", "hcloud-ip-floater", "other"},
+			wantUpdated: true,
+		},
+		{
+			name: "unmanaged pools with our names are replaced",
+			existing: &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+				Data: map[string]string{"config": `
+address-pools:
+- name: other
+  protocol: layer2
+  addresses:
+  - 192.0.2.99/32
+`},
+			},
+			wantPools:   []string{"hcloud-ip-floater", "other"},
+			wantUpdated: true,
+		},
+		{
+			name: "unchanged",
+			existing: &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace:   namespace,
+					Name:        name,
+					Annotations: map[string]string{managedPoolsAnnotation: "hcloud-ip-floater,other"},
+				},
+				Data: map[string]string{"config": `address-pools:
+- addresses:
+  - 192.0.2.1/32
+  - 192.0.2.2/32
+  name: hcloud-ip-floater
+  protocol: layer2
+- addresses:
+  - 2001:db8:1::/64
+  auto-assign: false
+  name: other
+  protocol: layer2
+`},
+			},
+			wantPools: []string{"hcloud-ip-floater", "other"},
+		},
+	}
+
+	saved := config.Global
+	t.Cleanup(func() { config.Global = saved })
+	config.Global.APITimeoutSeconds = 10
+	config.Global.MetalLBNamespace = namespace
+	config.Global.MetalLBConfigName = name
+	config.Global.MetalLBPoolLabel = "pool"
+	config.Global.MetalLBAutoAssignLabel = "auto-assign"
+
+	_, network, err := net.ParseCIDR("2001:db8:1::/64")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantAddresses := map[string][]interface{}{
+		"hcloud-ip-floater": {"192.0.2.1/32", "192.0.2.2/32"},
+		"other":             {"2001:db8:1::/64"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k8s := fake.NewSimpleClientset()
+			if tt.existing != nil {
+				k8s = fake.NewSimpleClientset(tt.existing)
+			}
+
+			fc := &Controller{
+				logger: newTestLogger(),
+				k8s:    k8s,
+				fips: map[string]*hcloud.FloatingIP{
+					"192.0.2.1": {ID: 1, IP: net.ParseIP("192.0.2.1")},
+					"192.0.2.2": {ID: 2, IP: net.ParseIP("192.0.2.2"), Labels: map[string]string{"pool": ""}},
+					"2001:db8:1::": {
+						ID:      3,
+						IP:      net.ParseIP("2001:db8:1::"),
+						Network: network,
+						Labels:  map[string]string{"pool": "other", "auto-assign": "false"},
+					},
+				},
+			}
+
+			if err := fc.syncMetalLBConfig(context.Background()); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			var updated bool
+			for _, action := range k8s.Actions() {
+				if action.GetVerb() == "create" || action.GetVerb() == "update" {
+					updated = true
+				}
+			}
+			if updated != tt.wantUpdated {
+				t.Errorf("got updated %t, want %t", updated, tt.wantUpdated)
+			}
+
+			cm, err := k8s.CoreV1().ConfigMaps(namespace).Get(context.Background(), name, metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("could not get config map: %s", err)
+			}
+
+			if got := cm.Annotations[managedPoolsAnnotation]; got != "hcloud-ip-floater,other" {
+				t.Errorf("got managed pools %q, want %q", got, "hcloud-ip-floater,other")
+			}
+
+			var cfg map[string]interface{}
+			if err := yaml.Unmarshal([]byte(cm.Data["config"]), &cfg); err != nil {
+				t.Fatalf("could not parse config: %s", err)
+			}
+
+			if _, hasPeers := cfg["peers"]; hasPeers != tt.wantPeers {
+				t.Errorf("got peers %t, want %t", hasPeers, tt.wantPeers)
+			}
+
+			pools, _ := cfg["address-pools"].([]interface{})
+			var names []string
+			for _, pool := range pools {
+				pool := pool.(map[string]interface{})
+				name, _ := pool["name"].(string)
+				names = append(names, name)
+
+				if want, ours := wantAddresses[name]; ours && !reflect.DeepEqual(pool["addresses"], want) {
+					t.Errorf("got addresses %v for pool %s, want %v", pool["addresses"], name, want)
+				}
+				if autoAssign, found := pool["auto-assign"]; (name == "other") != (found && autoAssign == false) {
+					t.Errorf("got auto-assign %v for pool %s", autoAssign, name)
+				}
+			}
+			if !reflect.DeepEqual(names, tt.wantPools) {
+				t.Errorf("got pools %v, want %v", names, tt.wantPools)
+			}
+		})
+	}
+}
@@ -0,0 +1,159 @@
+package fipcontroller
+
+import (
+	"context"
+	"reflect"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"github.com/costela/hcloud-ip-floater/internal/config"
+)
+
+const (
+	managedByLabel = "app.kubernetes.io/managed-by"
+	managedByValue = "hcloud-ip-floater"
+)
+
+var (
+	ipAddressPoolResource   = schema.GroupVersionResource{Group: "metallb.io", Version: "v1beta1", Resource: "ipaddresspools"}
+	l2AdvertisementResource = schema.GroupVersionResource{Group: "metallb.io", Version: "v1beta1", Resource: "l2advertisements"}
+)
+
+// syncMetalLBResources maintains one IPAddressPool (and accompanying L2Advertisement) per FIP pool, and removes the
+// ones we created for pools that no longer exist.
+func (fc *Controller) syncMetalLBResources(ctx context.Context) error {
+	ctx, cancel := APIContext(ctx)
+	defer cancel()
+
+	pools := fc.addressPools()
+
+	names := make(map[string]bool, len(pools))
+	for poolName, pool := range pools {
+		name := resourceName(poolName)
+		names[name] = true
+
+		addresses := make([]interface{}, 0, len(pool.addresses))
+		for _, address := range pool.addresses {
+			addresses = append(addresses, address)
+		}
+
+		if err := fc.applyMetalLBResource(ctx, ipAddressPoolResource, "IPAddressPool", name, map[string]interface{}{
+			"addresses":  addresses,
+			"autoAssign": pool.autoAssign,
+		}); err != nil {
+			return err
+		}
+
+		if err := fc.applyMetalLBResource(ctx, l2AdvertisementResource, "L2Advertisement", name, map[string]interface{}{
+			"ipAddressPools": []interface{}{name},
+		}); err != nil {
+			return err
+		}
+	}
+
+	for _, gvr := range []schema.GroupVersionResource{l2AdvertisementResource, ipAddressPoolResource} {
+		client := fc.dynamic.Resource(gvr).Namespace(config.Global.MetalLBNamespace)
+
+		list, err := client.List(ctx, metav1.ListOptions{LabelSelector: managedByLabel + "=" + managedByValue})
+		if err != nil {
+			return err
+		}
+
+		for _, item := range list.Items {
+			if names[item.GetName()] {
+				continue
+			}
+
+			if err := client.Delete(ctx, item.GetName(), metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+				return err
+			}
+
+			fc.logger.WithFields(logrus.Fields{
+				"namespace": config.Global.MetalLBNamespace,
+				"kind":      item.GetKind(),
+				"name":      item.GetName(),
+			}).Info("deleted MetalLB resource")
+		}
+	}
+
+	return nil
+}
+
+// applyMetalLBResource creates or updates a MetalLB resource so its spec matches the given one
+func (fc *Controller) applyMetalLBResource(ctx context.Context, gvr schema.GroupVersionResource, kind, name string, spec map[string]interface{}) error {
+	client := fc.dynamic.Resource(gvr).Namespace(config.Global.MetalLBNamespace)
+
+	logger := fc.logger.WithFields(logrus.Fields{
+		"namespace": config.Global.MetalLBNamespace,
+		"kind":      kind,
+		"name":      name,
+	})
+
+	existing, err := client.Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		obj := &unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": gvr.GroupVersion().String(),
+			"kind":       kind,
+			"metadata": map[string]interface{}{
+				"name":      name,
+				"namespace": config.Global.MetalLBNamespace,
+				"labels": map[string]interface{}{
+					managedByLabel: managedByValue,
+				},
+			},
+			"spec": spec,
+		}}
+
+		if _, err := client.Create(ctx, obj, metav1.CreateOptions{}); err != nil {
+			return err
+		}
+
+		logger.Info("created MetalLB resource")
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	// the API server defaults fields we don't set (e.g. avoidBuggyIPs), so only compare the ones we own
+	existingSpec, _ := existing.Object["spec"].(map[string]interface{})
+	if ownedFieldsMatch(existingSpec, spec) {
+		return nil
+	}
+
+	existing = existing.DeepCopy()
+	updatedSpec, _ := existing.Object["spec"].(map[string]interface{})
+	if updatedSpec == nil {
+		updatedSpec = make(map[string]interface{})
+	}
+	for key, value := range spec {
+		updatedSpec[key] = value
+	}
+	existing.Object["spec"] = updatedSpec
+
+	if _, err := client.Update(ctx, existing, metav1.UpdateOptions{}); err != nil {
+		return err
+	}
+
+	logger.Info("updated MetalLB resource")
+	return nil
+}
+
+// ownedFieldsMatch returns whether all fields of spec have the same value in existing
+func ownedFieldsMatch(existing, spec map[string]interface{}) bool {
+	for key, value := range spec {
+		if !reflect.DeepEqual(existing[key], value) {
+			return false
+		}
+	}
+	return true
+}
+
+// resourceName turns a pool label value into a valid kubernetes object name
+func resourceName(poolName string) string {
+	return strings.ReplaceAll(strings.ToLower(poolName), "_", "-")
+}
@@ -0,0 +1,76 @@
+package fipcontroller
+
+import (
+	"testing"
+)
+
+func TestOwnedFieldsMatch(t *testing.T) {
+	spec := map[string]interface{}{
+		"addresses":  []interface{}{"192.0.2.1/32", "192.0.2.2/32"},
+		"autoAssign": true,
+	}
+
+	tests := []struct {
+		name     string
+		existing map[string]interface{}
+		want     bool
+	}{
+		{
+			name:     "equal",
+			existing: map[string]interface{}{"addresses": []interface{}{"192.0.2.1/32", "192.0.2.2/32"}, "autoAssign": true},
+			want:     true,
+		},
+		{
+			name: "defaulted fields",
+			existing: map[string]interface{}{
+				"addresses":     []interface{}{"192.0.2.1/32", "192.0.2.2/32"},
+				"autoAssign":    true,
+				"avoidBuggyIPs": false,
+			},
+			want: true,
+		},
+		{
+			name:     "other addresses",
+			existing: map[string]interface{}{"addresses": []interface{}{"192.0.2.1/32"}, "autoAssign": true},
+		},
+		{
+			name:     "other order",
+			existing: map[string]interface{}{"addresses": []interface{}{"192.0.2.2/32", "192.0.2.1/32"}, "autoAssign": true},
+		},
+		{
+			name:     "other value",
+			existing: map[string]interface{}{"addresses": []interface{}{"192.0.2.1/32", "192.0.2.2/32"}, "autoAssign": false},
+		},
+		{
+			name:     "missing field",
+			existing: map[string]interface{}{"addresses": []interface{}{"192.0.2.1/32", "192.0.2.2/32"}},
+		},
+		{
+			name: "no spec",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ownedFieldsMatch(tt.existing, spec); got != tt.want {
+				t.Errorf("got %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResourceName(t *testing.T) {
+	tests := []struct {
+		poolName string
+		want     string
+	}{
+		{"hcloud-ip-floater", "hcloud-ip-floater"},
+		{"Public_Pool", "public-pool"},
+	}
+
+	for _, tt := range tests {
+		if got := resourceName(tt.poolName); got != tt.want {
+			t.Errorf("resourceName(%q) = %q, want %q", tt.poolName, got, tt.want)
+		}
+	}
+}
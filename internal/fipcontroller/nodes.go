@@ -0,0 +1,118 @@
+package fipcontroller
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hetznercloud/hcloud-go/hcloud"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/costela/hcloud-ip-floater/internal/config"
+)
+
+// strategies for mapping kubernetes nodes to hcloud servers
+const (
+	NodeMappingAuto       = "auto"        // try provider-id, then label, then name
+	NodeMappingProviderID = "provider-id" // spec.providerID as set by hcloud-cloud-controller-manager
+	NodeMappingLabel      = "label"       // value of the configured node label
+	NodeMappingName       = "name"        // node name equals server name
+)
+
+const (
+	providerIDPrefix      = "hcloud://"
+	robotProviderIDPrefix = "hrobot://"
+)
+
+// ValidNodeMapping returns whether the given node mapping strategy is supported
+func ValidNodeMapping(mapping string) bool {
+	switch mapping {
+	case NodeMappingAuto, NodeMappingProviderID, NodeMappingLabel, NodeMappingName:
+		return true
+	}
+	return false
+}
+
+// serverRef identifies the hcloud server backing a kubernetes node, either by ID or by name
+type serverRef struct {
+	id   int
+	name string
+}
+
+func (ref serverRef) String() string {
+	if ref.id != 0 {
+		return strconv.Itoa(ref.id)
+	}
+	return ref.name
+}
+
+// matches returns whether srv is the server referenced by ref. An empty ref only matches a nil server.
+func (ref serverRef) matches(srv *hcloud.Server) bool {
+	if srv == nil {
+		return ref == serverRef{}
+	}
+	if ref.id != 0 {
+		return srv.ID == ref.id
+	}
+	return srv.Name == ref.name
+}
+
+// serverRefForNode resolves the hcloud server for a node according to the configured node mapping strategy
+func serverRefForNode(node *corev1.Node) (serverRef, error) {
+	mapping := config.Global.NodeMapping
+
+	if mapping == NodeMappingAuto || mapping == NodeMappingProviderID {
+		if id, ok := serverIDFromProviderID(node.Spec.ProviderID); ok {
+			return serverRef{id: id}, nil
+		}
+		if mapping == NodeMappingProviderID {
+			return serverRef{}, fmt.Errorf("node %s has no hcloud provider ID", node.Name)
+		}
+	}
+
+	if mapping == NodeMappingAuto || mapping == NodeMappingLabel {
+		if value := node.Labels[config.Global.NodeServerLabel]; config.Global.NodeServerLabel != "" && value != "" {
+			if id, err := strconv.Atoi(value); err == nil {
+				return serverRef{id: id}, nil
+			}
+			return serverRef{name: value}, nil
+		}
+		if mapping == NodeMappingLabel {
+			return serverRef{}, fmt.Errorf("node %s has no %q label", node.Name, config.Global.NodeServerLabel)
+		}
+	}
+
+	return serverRef{name: node.Name}, nil
+}
+
+func serverIDFromProviderID(providerID string) (int, bool) {
+	if !strings.HasPrefix(providerID, providerIDPrefix) {
+		return 0, false
+	}
+
+	id, err := strconv.Atoi(strings.TrimPrefix(providerID, providerIDPrefix))
+	if err != nil || id <= 0 {
+		return 0, false
+	}
+
+	return id, true
+}
+
+// robotServerForNode resolves the Hetzner Robot server number of a dedicated server node, either from its provider ID
+// or from the configured node label
+func robotServerForNode(node *corev1.Node) (int, bool) {
+	value := strings.TrimPrefix(node.Spec.ProviderID, robotProviderIDPrefix)
+	if value == node.Spec.ProviderID {
+		value = node.Labels[config.Global.RobotServerLabel]
+		if config.Global.RobotServerLabel == "" {
+			value = ""
+		}
+	}
+
+	number, err := strconv.Atoi(value)
+	if err != nil || number <= 0 {
+		return 0, false
+	}
+
+	return number, true
+}
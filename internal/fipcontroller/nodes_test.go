@@ -0,0 +1,153 @@
+package fipcontroller
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/costela/hcloud-ip-floater/internal/config"
+)
+
+const testServerLabel = "example.com/server"
+
+func testNode(name, providerID string, labels map[string]string) *corev1.Node {
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels},
+		Spec:       corev1.NodeSpec{ProviderID: providerID},
+	}
+}
+
+func TestServerRefForNode(t *testing.T) {
+	tests := []struct {
+		name    string
+		mapping string
+		node    *corev1.Node
+		want    serverRef
+		wantErr bool
+	}{
+		{
+			name:    "auto: provider ID",
+			mapping: NodeMappingAuto,
+			node:    testNode("node", "hcloud://42", map[string]string{testServerLabel: "43"}),
+			want:    serverRef{id: 42},
+		},
+		{
+			name:    "auto: label with ID",
+			mapping: NodeMappingAuto,
+			node:    testNode("node", "", map[string]string{testServerLabel: "43"}),
+			want:    serverRef{id: 43},
+		},
+		{
+			name:    "auto: label with name",
+			mapping: NodeMappingAuto,
+			node:    testNode("node", "", map[string]string{testServerLabel: "server"}),
+			want:    serverRef{name: "server"},
+		},
+		{
+			name:    "auto: name",
+			mapping: NodeMappingAuto,
+			node:    testNode("node", "", nil),
+			want:    serverRef{name: "node"},
+		},
+		{
+			name:    "auto: foreign provider ID",
+			mapping: NodeMappingAuto,
+			node:    testNode("node", "aws:///eu-central-1a/i-1234", nil),
+			want:    serverRef{name: "node"},
+		},
+		{
+			name:    "auto: invalid provider ID",
+			mapping: NodeMappingAuto,
+			node:    testNode("node", "hcloud://0", nil),
+			want:    serverRef{name: "node"},
+		},
+		{
+			name:    "provider-id",
+			mapping: NodeMappingProviderID,
+			node:    testNode("node", "hcloud://42", nil),
+			want:    serverRef{id: 42},
+		},
+		{
+			name:    "provider-id: missing",
+			mapping: NodeMappingProviderID,
+			node:    testNode("node", "", map[string]string{testServerLabel: "43"}),
+			wantErr: true,
+		},
+		{
+			name:    "label",
+			mapping: NodeMappingLabel,
+			node:    testNode("node", "hcloud://42", map[string]string{testServerLabel: "43"}),
+			want:    serverRef{id: 43},
+		},
+		{
+			name:    "label: missing",
+			mapping: NodeMappingLabel,
+			node:    testNode("node", "hcloud://42", nil),
+			wantErr: true,
+		},
+		{
+			name:    "name",
+			mapping: NodeMappingName,
+			node:    testNode("node", "hcloud://42", map[string]string{testServerLabel: "43"}),
+			want:    serverRef{name: "node"},
+		},
+	}
+
+	saved := config.Global
+	t.Cleanup(func() { config.Global = saved })
+	config.Global.NodeServerLabel = testServerLabel
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config.Global.NodeMapping = tt.mapping
+
+			got, err := serverRefForNode(tt.node)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got %s", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRobotServerForNode(t *testing.T) {
+	tests := []struct {
+		name  string
+		label string // configured robot server label
+		node  *corev1.Node
+		want  int // 0 if not a robot server
+	}{
+		{"provider ID", testServerLabel, testNode("node", "hrobot://123", map[string]string{testServerLabel: "456"}), 123},
+		{"label", testServerLabel, testNode("node", "", map[string]string{testServerLabel: "456"}), 456},
+		{"hcloud provider ID", testServerLabel, testNode("node", "hcloud://42", nil), 0},
+		{"hcloud provider ID and label", testServerLabel, testNode("node", "hcloud://42", map[string]string{testServerLabel: "456"}), 456},
+		{"invalid provider ID", testServerLabel, testNode("node", "hrobot://foo", map[string]string{testServerLabel: "456"}), 0},
+		{"invalid label", testServerLabel, testNode("node", "", map[string]string{testServerLabel: "foo"}), 0},
+		{"negative label", testServerLabel, testNode("node", "", map[string]string{testServerLabel: "-1"}), 0},
+		{"no label configured", "", testNode("node", "", map[string]string{testServerLabel: "456"}), 0},
+		{"nothing", testServerLabel, testNode("node", "", nil), 0},
+	}
+
+	saved := config.Global
+	t.Cleanup(func() { config.Global = saved })
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config.Global.RobotServerLabel = tt.label
+
+			got, ok := robotServerForNode(tt.node)
+			if ok != (tt.want != 0) || got != tt.want {
+				t.Errorf("got %d (%t), want %d", got, ok, tt.want)
+			}
+		})
+	}
+}
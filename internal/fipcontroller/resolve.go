@@ -0,0 +1,226 @@
+package fipcontroller
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"time"
+
+	"github.com/hetznercloud/hcloud-go/hcloud"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/costela/hcloud-ip-floater/internal/stringset"
+)
+
+// fipForIPLocked finds the FIP a service IP belongs to. IPv4 FIPs only match their own address, while IPv6 FIPs are
+// whole networks and match any address inside them.
+// Must be called with fipsMu held.
+func (fc *Controller) fipForIPLocked(ip string) (string, *hcloud.FloatingIP, bool) {
+	if fip, found := fc.fips[ip]; found {
+		return ip, fip, true
+	}
+
+	parsed := net.ParseIP(ip)
+	if parsed == nil || parsed.To4() != nil {
+		return "", nil, false
+	}
+
+	for key, fip := range fc.fips {
+		if fip.Network != nil && fip.Network.Contains(parsed) {
+			return key, fip, true
+		}
+	}
+
+	return "", nil, false
+}
+
+// KnownIP returns whether the service IP belongs to one of the FIPs known to us or is handled by a backend
+func (fc *Controller) KnownIP(ip string) bool {
+	fc.fipsMu.RLock()
+	defer fc.fipsMu.RUnlock()
+
+	if _, _, found := fc.fipForIPLocked(ip); found {
+		return true
+	}
+
+	return fc.backendFor(ip) != nil
+}
+
+// desiredAttachmentsLocked maps each FIP to its desired attachment. Several service IPs may map to the same IPv6 FIP;
+// if they disagree on the node, the lowest service IP wins and the conflict is logged.
+// Must be called with fipsMu held.
+func (fc *Controller) desiredAttachmentsLocked() map[string]attachment {
+	fc.attMu.RLock()
+	defer fc.attMu.RUnlock()
+
+	svcIPs := make([]string, 0, len(fc.attachments))
+	for ip := range fc.attachments {
+		svcIPs = append(svcIPs, ip)
+	}
+	sort.Strings(svcIPs)
+
+	desired := make(map[string]attachment, len(svcIPs))
+	for _, ip := range svcIPs {
+		key, _, found := fc.fipForIPLocked(ip)
+		if !found {
+			continue
+		}
+
+		att := fc.attachments[ip]
+		if other, found := desired[key]; found {
+			if other.node != att.node {
+				fc.logger.WithFields(logrus.Fields{
+					"fip":        key,
+					"ip":         ip,
+					"node":       att.node,
+					"other_node": other.node,
+				}).Warn("services sharing a floating IP disagree on node")
+			}
+			continue
+		}
+		desired[key] = att
+	}
+
+	return desired
+}
+
+// SharedNode returns the node other services sharing a FIP with the given service IPs want it attached to. This can
+// only happen with IPv6 FIPs, where several services may use addresses from the same network.
+func (fc *Controller) SharedNode(svc *corev1.Service, svcIPs stringset.StringSet) (string, bool) {
+	svcKey, err := cache.MetaNamespaceKeyFunc(svc)
+	if err != nil {
+		return "", false
+	}
+
+	fc.fipsMu.RLock()
+	defer fc.fipsMu.RUnlock()
+
+	fipKeys := make(stringset.StringSet)
+	for ip := range svcIPs {
+		if key, _, found := fc.fipForIPLocked(ip); found {
+			fipKeys.Add(key)
+		}
+	}
+
+	fc.attMu.RLock()
+	defer fc.attMu.RUnlock()
+
+	for ip, att := range fc.attachments {
+		if svcIPs.Has(ip) {
+			continue
+		}
+		if otherKey, err := cache.MetaNamespaceKeyFunc(att.svc); err != nil || otherKey == svcKey {
+			continue
+		}
+		if key, _, found := fc.fipForIPLocked(ip); found && fipKeys.Has(key) {
+			return att.node, true
+		}
+	}
+
+	return "", false
+}
+
+// CurrentNode returns the node, out of the given candidates, that the service IPs are currently attached to. FIPs are
+// looked up in the last hcloud sync; IPs of other backends in our own attachments.
+func (fc *Controller) CurrentNode(svcIPs stringset.StringSet, candidates []*corev1.Node) (string, bool) {
+	fc.fipsMu.RLock()
+	defer fc.fipsMu.RUnlock()
+
+	fc.attMu.RLock()
+	defer fc.attMu.RUnlock()
+
+	ips := make([]string, 0, len(svcIPs))
+	for ip := range svcIPs {
+		ips = append(ips, ip)
+	}
+	sort.Strings(ips)
+
+	for _, ip := range ips {
+		if _, fip, found := fc.fipForIPLocked(ip); found {
+			if fip.Server == nil {
+				continue
+			}
+			for _, node := range candidates {
+				if server, err := serverRefForNode(node); err == nil && server.matches(fip.Server) {
+					return node.Name, true
+				}
+			}
+		} else if att, found := fc.attachments[ip]; found {
+			for _, node := range candidates {
+				if node.Name == att.node {
+					return node.Name, true
+				}
+			}
+		}
+	}
+
+	return "", false
+}
+
+// LastMove returns when any of the FIPs used by the service IPs was last moved by us
+func (fc *Controller) LastMove(svcIPs stringset.StringSet) time.Time {
+	fc.fipsMu.RLock()
+	defer fc.fipsMu.RUnlock()
+
+	fc.movesMu.Lock()
+	defer fc.movesMu.Unlock()
+
+	var last time.Time
+	for ip := range svcIPs {
+		if moved := fc.moves[fc.fipKeyLocked(ip)]; moved.After(last) {
+			last = moved
+		}
+	}
+
+	return last
+}
+
+// attachFailed returns whether the last attachment of any of the FIPs used by the service IPs failed
+func (fc *Controller) attachFailed(svcIPs stringset.StringSet) bool {
+	fc.fipsMu.RLock()
+	defer fc.fipsMu.RUnlock()
+
+	fc.movesMu.Lock()
+	defer fc.movesMu.Unlock()
+
+	for ip := range svcIPs {
+		if fc.failed.Has(fc.fipKeyLocked(ip)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// fipKeyLocked returns the key attachments of the service IP are tracked by: the FIP's for FIPs, the IP itself for
+// other backends. Must be called with fipsMu held.
+func (fc *Controller) fipKeyLocked(ip string) string {
+	if key, _, found := fc.fipForIPLocked(ip); found {
+		return key
+	}
+	return ip
+}
+
+// serviceErrors picks the errors of the FIPs used by the service IPs out of a reconciliation result
+func (fc *Controller) serviceErrors(svcIPs stringset.StringSet, errs map[string]error) error {
+	fc.fipsMu.RLock()
+	defer fc.fipsMu.RUnlock()
+
+	ips := make([]string, 0, len(svcIPs))
+	for ip := range svcIPs {
+		ips = append(ips, ip)
+	}
+	sort.Strings(ips)
+
+	var svcErrs []error
+	for _, ip := range ips {
+		if err, found := errs[fc.fipKeyLocked(ip)]; found {
+			svcErrs = append(svcErrs, fmt.Errorf("%s: %w", ip, err))
+		}
+	}
+
+	return errors.Join(svcErrs...)
+}
@@ -0,0 +1,219 @@
+package fipcontroller
+
+import (
+	"io"
+	"net"
+	"testing"
+
+	"github.com/hetznercloud/hcloud-go/hcloud"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/costela/hcloud-ip-floater/internal/stringset"
+)
+
+func newTestLogger() logrus.FieldLogger {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	return logger
+}
+
+func testFIPs(t *testing.T) map[string]*hcloud.FloatingIP {
+	_, network, err := net.ParseCIDR("2001:db8:1::/64")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return map[string]*hcloud.FloatingIP{
+		"192.0.2.1": {ID: 1, Type: hcloud.FloatingIPTypeIPv4, IP: net.ParseIP("192.0.2.1")},
+		"2001:db8:1::": {
+			ID:      2,
+			Type:    hcloud.FloatingIPTypeIPv6,
+			IP:      net.ParseIP("2001:db8:1::"),
+			Network: network,
+		},
+	}
+}
+
+func TestFIPForIPLocked(t *testing.T) {
+	fc := &Controller{logger: newTestLogger(), fips: testFIPs(t)}
+
+	tests := []struct {
+		name    string
+		ip      string
+		wantKey string // empty if no FIP is expected
+	}{
+		{"IPv4 FIP", "192.0.2.1", "192.0.2.1"},
+		{"other IPv4 address", "192.0.2.2", ""},
+		{"IPv6 network base", "2001:db8:1::", "2001:db8:1::"},
+		{"IPv6 host address", "2001:db8:1::1", "2001:db8:1::"},
+		{"IPv6 address inside network", "2001:db8:1::ffff:1", "2001:db8:1::"},
+		{"IPv6 address outside network", "2001:db8:2::1", ""},
+		{"IPv4-mapped IPv6 address", "::ffff:192.0.2.1", ""},
+		{"invalid address", "foo", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, fip, found := fc.fipForIPLocked(tt.ip)
+			if tt.wantKey == "" {
+				if found {
+					t.Errorf("got FIP %s, want none", key)
+				}
+				return
+			}
+			if !found {
+				t.Fatalf("got no FIP, want %s", tt.wantKey)
+			}
+			if key != tt.wantKey || fip != fc.fips[tt.wantKey] {
+				t.Errorf("got FIP %s, want %s", key, tt.wantKey)
+			}
+		})
+	}
+}
+
+func TestDesiredAttachmentsLocked(t *testing.T) {
+	tests := []struct {
+		name        string
+		attachments map[string]string // service IP to node
+		want        map[string]string // FIP to node
+	}{
+		{
+			name: "none",
+			want: map[string]string{},
+		},
+		{
+			name:        "IPv4",
+			attachments: map[string]string{"192.0.2.1": "node-a"},
+			want:        map[string]string{"192.0.2.1": "node-a"},
+		},
+		{
+			name:        "unknown IPs are skipped",
+			attachments: map[string]string{"192.0.2.2": "node-a", "2001:db8:2::1": "node-b"},
+			want:        map[string]string{},
+		},
+		{
+			name:        "IPv6 addresses map to their network",
+			attachments: map[string]string{"2001:db8:1::1": "node-a", "192.0.2.1": "node-b"},
+			want:        map[string]string{"2001:db8:1::": "node-a", "192.0.2.1": "node-b"},
+		},
+		{
+			name:        "IPv6 addresses sharing a network agree",
+			attachments: map[string]string{"2001:db8:1::1": "node-a", "2001:db8:1::2": "node-a"},
+			want:        map[string]string{"2001:db8:1::": "node-a"},
+		},
+		{
+			name:        "lowest IPv6 address wins on conflict",
+			attachments: map[string]string{"2001:db8:1::2": "node-a", "2001:db8:1::1": "node-b", "2001:db8:1::3": "node-c"},
+			want:        map[string]string{"2001:db8:1::": "node-b"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fc := &Controller{
+				logger:      newTestLogger(),
+				fips:        testFIPs(t),
+				attachments: make(map[string]attachment),
+			}
+			for ip, node := range tt.attachments {
+				fc.attachments[ip] = attachment{node: node, server: serverRef{name: node}}
+			}
+
+			got := fc.desiredAttachmentsLocked()
+
+			if len(got) != len(tt.want) {
+				t.Errorf("got %d attachments, want %d", len(got), len(tt.want))
+			}
+			for fip, node := range tt.want {
+				if att, found := got[fip]; !found {
+					t.Errorf("missing attachment for %s", fip)
+				} else if att.node != node {
+					t.Errorf("got node %s for %s, want %s", att.node, fip, node)
+				}
+			}
+		})
+	}
+}
+
+func TestSharedNode(t *testing.T) {
+	svcA := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "a"}}
+	svcB := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "b"}}
+
+	tests := []struct {
+		name     string
+		others   map[string]string // service IPs of svcB to node
+		forget   []string          // service IPs of svcB forgotten again, e.g. because it was deleted
+		svcIPs   []string          // service IPs of svcA
+		wantNode string            // empty if not shared
+	}{
+		{
+			name:   "no other services",
+			svcIPs: []string{"2001:db8:1::1"},
+		},
+		{
+			name:     "other service in the same network",
+			others:   map[string]string{"2001:db8:1::2": "node-b"},
+			svcIPs:   []string{"2001:db8:1::1"},
+			wantNode: "node-b",
+		},
+		{
+			name:   "other service in another network",
+			others: map[string]string{"2001:db8:2::2": "node-b"},
+			svcIPs: []string{"2001:db8:1::1"},
+		},
+		{
+			name:   "other service with another IPv4 FIP",
+			others: map[string]string{"192.0.2.1": "node-b"},
+			svcIPs: []string{"2001:db8:1::1"},
+		},
+		{
+			name:   "other service in the same network deleted",
+			others: map[string]string{"2001:db8:1::2": "node-b"},
+			forget: []string{"2001:db8:1::2"},
+			svcIPs: []string{"2001:db8:1::1"},
+		},
+		{
+			name:   "unknown IP",
+			others: map[string]string{"2001:db8:1::2": "node-b"},
+			svcIPs: []string{"2001:db8:3::1"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fc := &Controller{
+				logger:      newTestLogger(),
+				fips:        testFIPs(t),
+				attachments: make(map[string]attachment),
+			}
+
+			svcIPs := make(stringset.StringSet)
+			for _, ip := range tt.svcIPs {
+				svcIPs.Add(ip)
+				fc.attachments[ip] = attachment{node: "node-a", server: serverRef{name: "node-a"}, svc: svcA}
+			}
+			for ip, node := range tt.others {
+				fc.attachments[ip] = attachment{node: node, server: serverRef{name: node}, svc: svcB}
+			}
+
+			forget := make(stringset.StringSet)
+			for _, ip := range tt.forget {
+				forget.Add(ip)
+			}
+			fc.ForgetAttachments(forget)
+
+			node, shared := fc.SharedNode(svcA, svcIPs)
+			if tt.wantNode == "" {
+				if shared {
+					t.Errorf("got shared node %s, want none", node)
+				}
+				return
+			}
+			if !shared || node != tt.wantNode {
+				t.Errorf("got shared node %q (%t), want %s", node, shared, tt.wantNode)
+			}
+		})
+	}
+}
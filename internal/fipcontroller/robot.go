@@ -0,0 +1,293 @@
+package fipcontroller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const robotEndpoint = "https://robot-ws.your-server.de"
+
+// robotClienter is the subset of the Hetzner Robot webservice used for failover IPs
+type robotClienter interface {
+	FailoverIPs(context.Context) ([]robotFailover, error)
+	Server(context.Context, int) (*robotServer, error)
+	SwitchFailoverIP(ctx context.Context, ip string, activeServerIP string) (*robotFailover, error)
+}
+
+type robotFailover struct {
+	IP             string `json:"ip"`
+	Netmask        string `json:"netmask"`
+	ServerNumber   int    `json:"server_number"`
+	ActiveServerIP string `json:"active_server_ip"`
+}
+
+// network returns the addresses routed by the failover IP; IPv6 failover IPs are whole networks
+func (fo *robotFailover) network() *net.IPNet {
+	ip := net.ParseIP(fo.IP)
+	if ip == nil {
+		return nil
+	}
+
+	// a missing or invalid netmask parses to nil, as does an IPv6 netmask for an IPv4 address
+	maskIP := net.ParseIP(fo.Netmask)
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+		maskIP = maskIP.To4()
+	}
+	mask := net.IPMask(maskIP)
+	if ones, bits := mask.Size(); (ones == 0 && bits == 0) || bits != 8*len(ip) {
+		// not a canonical mask; only match the address itself
+		mask = net.CIDRMask(8*len(ip), 8*len(ip))
+	}
+
+	return &net.IPNet{IP: ip.Mask(mask), Mask: mask}
+}
+
+type robotServer struct {
+	ServerIP      string `json:"server_ip"`
+	ServerIPv6Net string `json:"server_ipv6_net"`
+	ServerNumber  int    `json:"server_number"`
+}
+
+type robotClient struct {
+	httpClient *http.Client
+	endpoint   string
+	user       string
+	password   string
+}
+
+func newRobotClient(user, password string) *robotClient {
+	return &robotClient{
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+		endpoint:   robotEndpoint,
+		user:       user,
+		password:   password,
+	}
+}
+
+func (rc *robotClient) FailoverIPs(ctx context.Context) ([]robotFailover, error) {
+	var resp []struct {
+		Failover robotFailover `json:"failover"`
+	}
+	if err := rc.do(ctx, http.MethodGet, "/failover", nil, &resp); err != nil {
+		return nil, err
+	}
+
+	failovers := make([]robotFailover, 0, len(resp))
+	for _, r := range resp {
+		failovers = append(failovers, r.Failover)
+	}
+
+	return failovers, nil
+}
+
+func (rc *robotClient) Server(ctx context.Context, number int) (*robotServer, error) {
+	var resp struct {
+		Server robotServer `json:"server"`
+	}
+	if err := rc.do(ctx, http.MethodGet, fmt.Sprintf("/server/%d", number), nil, &resp); err != nil {
+		return nil, err
+	}
+
+	return &resp.Server, nil
+}
+
+func (rc *robotClient) SwitchFailoverIP(ctx context.Context, ip string, activeServerIP string) (*robotFailover, error) {
+	var resp struct {
+		Failover robotFailover `json:"failover"`
+	}
+	form := url.Values{"active_server_ip": {activeServerIP}}
+	if err := rc.do(ctx, http.MethodPost, "/failover/"+ip, form, &resp); err != nil {
+		return nil, err
+	}
+
+	return &resp.Failover, nil
+}
+
+func (rc *robotClient) do(ctx context.Context, method, path string, form url.Values, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, method, rc.endpoint+path, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(rc.user, rc.password)
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+
+	resp, err := rc.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var errResp struct {
+			Error struct {
+				Code    string `json:"code"`
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&errResp); err != nil || errResp.Error.Code == "" {
+			return fmt.Errorf("robot API %s %s: %s", method, path, resp.Status)
+		}
+		return fmt.Errorf("robot API %s %s: %s: %s", method, path, errResp.Error.Code, errResp.Error.Message)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// robotMinSyncInterval limits how often failover IPs are fetched, since the Robot webservice only allows few requests
+// per hour. Our own switches are tracked in between.
+const robotMinSyncInterval = time.Minute
+
+// robotBackend switches Hetzner Robot failover IPs between dedicated servers
+type robotBackend struct {
+	robotClient robotClienter
+
+	failovers   map[string]robotFailover
+	lastSync    time.Time
+	failoversMu sync.RWMutex
+
+	// server IPs don't change, so they are only fetched once per server
+	servers   map[int]robotServer
+	serversMu sync.Mutex
+}
+
+func (b *robotBackend) name() string {
+	return "robot"
+}
+
+func (b *robotBackend) sync(ctx context.Context) error {
+	b.failoversMu.RLock()
+	recent := time.Since(b.lastSync) < robotMinSyncInterval
+	b.failoversMu.RUnlock()
+	if recent {
+		return nil
+	}
+
+	ctx, cancel := APIContext(ctx)
+	defer cancel()
+
+	failovers, err := b.robotClient.FailoverIPs(ctx)
+	if err != nil {
+		return err
+	}
+
+	byIP := make(map[string]robotFailover, len(failovers))
+	for _, fo := range failovers {
+		byIP[fo.IP] = fo
+	}
+
+	b.failoversMu.Lock()
+	b.failovers = byIP
+	b.lastSync = time.Now()
+	b.failoversMu.Unlock()
+
+	return nil
+}
+
+// failoverFor returns the failover IP routing the given service IP
+func (b *robotBackend) failoverFor(ip net.IP) (robotFailover, bool) {
+	b.failoversMu.RLock()
+	defer b.failoversMu.RUnlock()
+
+	for _, fo := range b.failovers {
+		if network := fo.network(); network != nil && network.Contains(ip) {
+			return fo, true
+		}
+	}
+
+	return robotFailover{}, false
+}
+
+func (b *robotBackend) handles(ip net.IP) bool {
+	_, found := b.failoverFor(ip)
+	return found
+}
+
+func (b *robotBackend) floatingIPs() []FloatingIP {
+	b.failoversMu.RLock()
+	defer b.failoversMu.RUnlock()
+
+	fips := make([]FloatingIP, 0, len(b.failovers))
+	for ip := range b.failovers {
+		fips = append(fips, FloatingIP{IP: ip})
+	}
+
+	sort.Slice(fips, func(i, j int) bool {
+		return fips[i].IP < fips[j].IP
+	})
+
+	return fips
+}
+
+func (b *robotBackend) attach(ctx context.Context, ip net.IP, att attachment) (bool, error) {
+	fo, found := b.failoverFor(ip)
+	if !found {
+		return false, fmt.Errorf("could not find failover IP for %s", ip)
+	}
+
+	if att.robotServer == 0 {
+		return false, fmt.Errorf("node %s is not a Hetzner Robot server", att.node)
+	}
+
+	server, err := b.server(ctx, att.robotServer)
+	if err != nil {
+		return false, err
+	}
+
+	// IPv6 failover networks are routed to the server's IPv6 network instead of its main IP
+	activeServerIP := server.ServerIP
+	if net.ParseIP(fo.IP).To4() == nil {
+		_, serverNet, err := net.ParseCIDR(server.ServerIPv6Net + "/64")
+		if err != nil {
+			return false, fmt.Errorf("server %d has no usable IPv6 network: %w", att.robotServer, err)
+		}
+		activeServerIP = serverNet.IP.String()
+	}
+
+	if fo.ActiveServerIP == activeServerIP {
+		return false, nil
+	}
+
+	updated, err := b.robotClient.SwitchFailoverIP(ctx, fo.IP, activeServerIP)
+	if err != nil {
+		return false, err
+	}
+
+	b.failoversMu.Lock()
+	b.failovers[updated.IP] = *updated
+	b.failoversMu.Unlock()
+
+	return true, nil
+}
+
+// server returns the server with the given number, fetching it only on first use
+func (b *robotBackend) server(ctx context.Context, number int) (robotServer, error) {
+	b.serversMu.Lock()
+	defer b.serversMu.Unlock()
+
+	if server, ok := b.servers[number]; ok {
+		return server, nil
+	}
+
+	server, err := b.robotClient.Server(ctx, number)
+	if err != nil {
+		return robotServer{}, err
+	}
+
+	if b.servers == nil {
+		b.servers = make(map[int]robotServer)
+	}
+	b.servers[number] = *server
+
+	return *server, nil
+}
@@ -0,0 +1,146 @@
+package fipcontroller
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestRobotFailoverNetwork(t *testing.T) {
+	tests := []struct {
+		name    string
+		ip      string
+		netmask string
+		want    string // empty for nil
+	}{
+		{"IPv4 host", "192.0.2.10", "255.255.255.255", "192.0.2.10/32"},
+		{"IPv4 subnet", "192.0.2.10", "255.255.255.248", "192.0.2.8/29"},
+		{"IPv6 network", "2001:db8:1::", "ffff:ffff:ffff:ffff::", "2001:db8:1::/64"},
+		{"IPv6 address inside network", "2001:db8:1::5", "ffff:ffff:ffff:ffff::", "2001:db8:1::/64"},
+		{"missing netmask", "192.0.2.10", "", "192.0.2.10/32"},
+		{"invalid netmask", "192.0.2.10", "foo", "192.0.2.10/32"},
+		{"non-canonical netmask", "192.0.2.10", "255.0.255.0", "192.0.2.10/32"},
+		{"IPv6 netmask for IPv4 address", "192.0.2.10", "ffff:ffff:ffff:ffff::", "192.0.2.10/32"},
+		{"IPv4 netmask for IPv6 address", "2001:db8:1::5", "255.255.255.0", "2001:db8:1::5/128"},
+		{"invalid IP", "foo", "255.255.255.255", ""},
+		{"missing IP", "", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fo := robotFailover{IP: tt.ip, Netmask: tt.netmask}
+
+			got := fo.network()
+			if tt.want == "" {
+				if got != nil {
+					t.Errorf("got %s, want nil", got)
+				}
+				return
+			}
+			if got == nil {
+				t.Fatalf("got nil, want %s", tt.want)
+			}
+			if got.String() != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// mockRobotClient serves fixed servers and records failover switches
+type mockRobotClient struct {
+	servers       map[int]robotServer
+	serverLookups int
+	switches      []string // "ip→active server IP"
+}
+
+func (m *mockRobotClient) FailoverIPs(context.Context) ([]robotFailover, error) {
+	return nil, nil
+}
+
+func (m *mockRobotClient) Server(_ context.Context, number int) (*robotServer, error) {
+	m.serverLookups++
+	server, ok := m.servers[number]
+	if !ok {
+		return nil, fmt.Errorf("server %d not found", number)
+	}
+	return &server, nil
+}
+
+func (m *mockRobotClient) SwitchFailoverIP(_ context.Context, ip string, activeServerIP string) (*robotFailover, error) {
+	m.switches = append(m.switches, ip+"→"+activeServerIP)
+	netmask := "255.255.255.255"
+	if net.ParseIP(ip).To4() == nil {
+		netmask = "ffff:ffff:ffff:ffff::"
+	}
+	return &robotFailover{IP: ip, Netmask: netmask, ActiveServerIP: activeServerIP}, nil
+}
+
+func TestRobotBackendAttach(t *testing.T) {
+	servers := map[int]robotServer{
+		1: {ServerNumber: 1, ServerIP: "198.51.100.1", ServerIPv6Net: "2001:db8:a::"},
+		2: {ServerNumber: 2, ServerIP: "198.51.100.2", ServerIPv6Net: "2001:db8:b::"},
+	}
+
+	tests := []struct {
+		name        string
+		ip          string
+		robotServer int
+		wantChanged bool
+		wantSwitch  string // empty if no switch is expected
+		wantErr     bool
+	}{
+		{"IPv4 already active", "192.0.2.1", 1, false, "", false},
+		{"IPv4 switched", "192.0.2.1", 2, true, "192.0.2.1→198.51.100.2", false},
+		{"IPv6 already active", "2001:db8:1::1", 1, false, "", false},
+		{"IPv6 switched", "2001:db8:1::1", 2, true, "2001:db8:1::→2001:db8:b::", false},
+		{"not a robot server", "192.0.2.1", 0, false, "", true},
+		{"unknown server", "192.0.2.1", 3, false, "", true},
+		{"unknown failover IP", "192.0.2.2", 2, false, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &mockRobotClient{servers: servers}
+			b := &robotBackend{
+				robotClient: client,
+				failovers: map[string]robotFailover{
+					"192.0.2.1":    {IP: "192.0.2.1", Netmask: "255.255.255.255", ActiveServerIP: "198.51.100.1"},
+					"2001:db8:1::": {IP: "2001:db8:1::", Netmask: "ffff:ffff:ffff:ffff::", ActiveServerIP: "2001:db8:a::"},
+				},
+			}
+
+			changed, err := b.attach(context.Background(), net.ParseIP(tt.ip), attachment{node: "node", robotServer: tt.robotServer})
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if changed != tt.wantChanged {
+				t.Errorf("got changed %t, want %t", changed, tt.wantChanged)
+			}
+
+			var wantSwitches []string
+			if tt.wantSwitch != "" {
+				wantSwitches = []string{tt.wantSwitch}
+			}
+			if !reflect.DeepEqual(client.switches, wantSwitches) {
+				t.Errorf("got switches %v, want %v", client.switches, wantSwitches)
+			}
+
+			// the switch is remembered until the next sync, and the server is only looked up once
+			if changed, err := b.attach(context.Background(), net.ParseIP(tt.ip), attachment{node: "node", robotServer: tt.robotServer}); err != nil || changed {
+				t.Errorf("got changed %t (%v) when attaching again", changed, err)
+			}
+			if client.serverLookups != 1 {
+				t.Errorf("got %d server lookups, want 1", client.serverLookups)
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package fipcontroller
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/hetznercloud/hcloud-go/hcloud"
+)
+
+// routeBackend makes virtual service IPs reachable inside an hcloud network by pointing a route's gateway at the
+// private IP of the selected server
+type routeBackend struct {
+	networkCache
+}
+
+func (b *routeBackend) name() string {
+	return "route"
+}
+
+// handles returns true for IPs covered by one of the network's routes, and for IPs inside the network's range but
+// outside all of its subnets, for which a host route is created
+func (b *routeBackend) handles(ip net.IP) bool {
+	network := b.getNetwork()
+	if network == nil {
+		return false
+	}
+
+	if _, found := routeFor(network, ip); found {
+		return true
+	}
+
+	if network.IPRange == nil || !network.IPRange.Contains(ip) {
+		return false
+	}
+	for _, subnet := range network.Subnets {
+		if subnet.IPRange != nil && subnet.IPRange.Contains(ip) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (b *routeBackend) attach(ctx context.Context, ip net.IP, att attachment) (bool, error) {
+	network := b.getNetwork()
+	if network == nil {
+		return false, fmt.Errorf("network %s not synced yet", b.networkRef)
+	}
+
+	target, err := getServer(ctx, b.hcloudClient, att)
+	if err != nil {
+		return false, err
+	}
+
+	pn := privateNet(target, network)
+	if pn == nil {
+		return false, fmt.Errorf("server %s is not attached to network %s", target.Name, network.Name)
+	}
+
+	route, found := routeFor(network, ip)
+	if found && route.Gateway.Equal(pn.IP) {
+		return false, nil
+	}
+
+	if !found {
+		bits := 8 * net.IPv4len
+		if ip.To4() == nil {
+			bits = 8 * net.IPv6len
+		}
+		route.Destination = &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+	} else {
+		// routes can't be updated in place, so the old one has to go first
+		act, _, err := b.hcloudClient.Network().DeleteRoute(ctx, network, hcloud.NetworkDeleteRouteOpts{Route: route})
+		if err != nil {
+			return false, fmt.Errorf("could not delete route to %s: %w", route.Destination, err)
+		}
+		if err := waitForAction(ctx, b.hcloudClient, act); err != nil {
+			return false, fmt.Errorf("could not delete route to %s: %w", route.Destination, err)
+		}
+	}
+
+	route.Gateway = pn.IP
+	act, _, err := b.hcloudClient.Network().AddRoute(ctx, network, hcloud.NetworkAddRouteOpts{Route: route})
+	if err == nil {
+		err = waitForAction(ctx, b.hcloudClient, act)
+	}
+	if err != nil {
+		return true, fmt.Errorf("could not add route to %s via %s: %w", route.Destination, route.Gateway, err)
+	}
+
+	// refresh the routes, so further IPs covered by the same route see the new gateway
+	return true, b.sync(ctx)
+}
+
+// routeFor returns the most specific route of the network covering the given IP
+func routeFor(network *hcloud.Network, ip net.IP) (hcloud.NetworkRoute, bool) {
+	var best hcloud.NetworkRoute
+	bestOnes := -1
+
+	for _, route := range network.Routes {
+		if route.Destination == nil || !route.Destination.Contains(ip) {
+			continue
+		}
+		if ones, _ := route.Destination.Mask.Size(); ones > bestOnes {
+			best = route
+			bestOnes = ones
+		}
+	}
+
+	return best, bestOnes >= 0
+}
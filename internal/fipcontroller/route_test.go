@@ -0,0 +1,65 @@
+package fipcontroller
+
+import (
+	"net"
+	"testing"
+
+	"github.com/hetznercloud/hcloud-go/hcloud"
+)
+
+func TestRouteFor(t *testing.T) {
+	mustParseCIDR := func(cidr string) *net.IPNet {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return network
+	}
+
+	network := &hcloud.Network{
+		Routes: []hcloud.NetworkRoute{
+			{Destination: mustParseCIDR("10.1.0.0/16"), Gateway: net.ParseIP("10.0.0.2")},
+			{Destination: mustParseCIDR("10.1.2.0/24"), Gateway: net.ParseIP("10.0.0.3")},
+			{Destination: mustParseCIDR("10.1.2.3/32"), Gateway: net.ParseIP("10.0.0.4")},
+			{Destination: nil, Gateway: net.ParseIP("10.0.0.5")},
+			{Destination: mustParseCIDR("192.0.2.0/24"), Gateway: net.ParseIP("10.0.0.6")},
+		},
+	}
+
+	tests := []struct {
+		name        string
+		ip          string
+		wantGateway string // empty if no route is expected
+	}{
+		{"most specific host route", "10.1.2.3", "10.0.0.4"},
+		{"subnet route", "10.1.2.4", "10.0.0.3"},
+		{"least specific route", "10.1.3.1", "10.0.0.2"},
+		{"unrelated route", "192.0.2.1", "10.0.0.6"},
+		{"no route", "10.2.0.1", ""},
+		{"IPv6", "2001:db8::1", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			route, found := routeFor(network, net.ParseIP(tt.ip))
+			if tt.wantGateway == "" {
+				if found {
+					t.Errorf("got route to %s via %s, want none", route.Destination, route.Gateway)
+				}
+				return
+			}
+			if !found {
+				t.Fatalf("got no route, want one via %s", tt.wantGateway)
+			}
+			if route.Gateway.String() != tt.wantGateway {
+				t.Errorf("got route to %s via %s, want via %s", route.Destination, route.Gateway, tt.wantGateway)
+			}
+		})
+	}
+
+	t.Run("no routes", func(t *testing.T) {
+		if route, found := routeFor(&hcloud.Network{}, net.ParseIP("10.1.2.3")); found {
+			t.Errorf("got route to %s via %s, want none", route.Destination, route.Gateway)
+		}
+	})
+}
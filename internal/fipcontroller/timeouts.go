@@ -0,0 +1,39 @@
+package fipcontroller
+
+import (
+	"context"
+	"time"
+
+	"github.com/costela/hcloud-ip-floater/internal/config"
+)
+
+// APIContext bounds API calls used for syncing state by the configured timeout. It is shared with the service
+// controller, so all kubernetes and hcloud API calls use the same timeout.
+func APIContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, time.Duration(config.Global.APITimeoutSeconds)*time.Second)
+}
+
+// attachContext bounds an attachment, including waiting for it to complete, by the configured timeout. It is only
+// cancelled after the shutdown grace period once ctx is done, so in-flight attachments are not interrupted halfway.
+func attachContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	attachCtx, cancel := context.WithTimeout(context.Background(), time.Duration(config.Global.AttachTimeoutSeconds)*time.Second)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-attachCtx.Done():
+			return
+		}
+
+		grace := time.NewTimer(time.Duration(config.Global.ShutdownGraceSeconds) * time.Second)
+		defer grace.Stop()
+
+		select {
+		case <-grace.C:
+			cancel()
+		case <-attachCtx.Done():
+		}
+	}()
+
+	return attachCtx, cancel
+}
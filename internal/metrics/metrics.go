@@ -0,0 +1,158 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "hcloud_ip_floater"
+
+// Registry holds all metrics exposed by the controller
+var Registry = prometheus.NewRegistry()
+
+var (
+	Leader = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "leader",
+		Help:      "Whether this replica currently holds the leader election lease.",
+	})
+
+	FIPServer = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "fip_server_info",
+		Help:      "Server a floating IP is currently assigned to according to hcloud (empty if unassigned).",
+	}, []string{"fip", "server"})
+
+	FIPDesiredNode = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "fip_desired_node_info",
+		Help:      "Node a floating IP should be attached to according to the controller.",
+	}, []string{"fip", "node"})
+
+	Reconciliations = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "reconciliations_total",
+		Help:      "Number of reconciliation runs.",
+	})
+
+	ReconciliationFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "reconciliation_failures_total",
+		Help:      "Number of reconciliation runs where at least one floating IP could not be attached.",
+	})
+
+	ReconciliationDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "reconciliation_duration_seconds",
+		Help:      "Duration of reconciliation runs.",
+		Buckets:   prometheus.DefBuckets,
+	})
+
+	AssignDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "fip_assign_duration_seconds",
+		Help:      "Duration of floating IP assignments, including waiting for the hcloud action to finish.",
+		Buckets:   prometheus.ExponentialBuckets(0.25, 2, 8),
+	})
+
+	AssignErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "fip_assign_errors_total",
+		Help:      "Number of failed floating IP assignments.",
+	}, []string{"fip"})
+
+	HCloudRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "hcloud_requests_total",
+		Help:      "Number of requests to the hcloud API.",
+	}, []string{"method", "code"})
+
+	HCloudRateLimitRemaining = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "hcloud_ratelimit_remaining",
+		Help:      "Remaining hcloud API requests in the current rate-limit window, as of the last response.",
+	})
+
+	WatchedServices = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "watched_services",
+		Help:      "Number of services matching the service label selector.",
+	})
+
+	PodInformers = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "pod_informers",
+		Help:      "Number of per-service pod informers currently running.",
+	})
+
+	PinnedServices = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "pinned_service_info",
+		Help:      "Services whose IPs are pinned to a node by manual override, bypassing the election.",
+	}, []string{"namespace", "service", "node"})
+
+	ElectionPolicyErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "election_policy_errors_total",
+		Help:      "Number of elections in which a service's election policy could not be applied.",
+	}, []string{"namespace", "service"})
+
+	StartupAttachments = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "startup_attachments_total",
+		Help:      "Number of service IPs found attached on startup, by whether they were adopted or moved to another node.",
+	}, []string{"result"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		Leader,
+		FIPServer,
+		FIPDesiredNode,
+		Reconciliations,
+		ReconciliationFailures,
+		ReconciliationDuration,
+		AssignDuration,
+		AssignErrors,
+		HCloudRequests,
+		HCloudRateLimitRemaining,
+		WatchedServices,
+		PodInformers,
+		ElectionPolicyErrors,
+		PinnedServices,
+		StartupAttachments,
+	)
+}
+
+// Handler returns an HTTP handler exposing all metrics in Registry
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// InstrumentRoundTripper wraps an HTTP transport used for the hcloud API, counting requests and tracking the
+// remaining rate-limit budget reported by the API.
+func InstrumentRoundTripper(next http.RoundTripper) http.RoundTripper {
+	return promhttp.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		resp, err := next.RoundTrip(req)
+		if err != nil {
+			HCloudRequests.WithLabelValues(req.Method, "error").Inc()
+			return resp, err
+		}
+
+		HCloudRequests.WithLabelValues(req.Method, strconv.Itoa(resp.StatusCode)).Inc()
+
+		if h := resp.Header.Get("RateLimit-Remaining"); h != "" {
+			if remaining, err := strconv.Atoi(h); err == nil {
+				HCloudRateLimitRemaining.Set(float64(remaining))
+			}
+		}
+
+		return resp, err
+	})
+}
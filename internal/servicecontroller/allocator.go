@@ -0,0 +1,198 @@
+package servicecontroller
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/costela/hcloud-ip-floater/internal/config"
+	"github.com/costela/hcloud-ip-floater/internal/fipcontroller"
+	"github.com/costela/hcloud-ip-floater/internal/stringset"
+)
+
+const (
+	// floatingIPAnnotation requests a specific floating IP, by address or hcloud name
+	floatingIPAnnotation = "hcloud-ip-floater.cstl.dev/floating-ip"
+	// allocatedIPAnnotation records the floating IP allocated to a service; it survives status resets and restarts
+	allocatedIPAnnotation = "hcloud-ip-floater.cstl.dev/allocated-ip"
+)
+
+// allocateServiceIP makes sure the service has a floating IP allocated and published in its status, if IP allocation
+// is enabled. Changes to the service trigger a new update event, which handles the actual attachment.
+// Only called by the workers, which start once all services and FIPs are known, so we know which IPs are free.
+func (sc *Controller) allocateServiceIP(ctx context.Context, svc *corev1.Service) error {
+	if !config.Global.AllocateIPs {
+		return nil
+	}
+
+	svcKey, err := cache.MetaNamespaceKeyFunc(svc)
+	if err != nil {
+		return err
+	}
+
+	funcLogger := sc.Logger.WithFields(logrus.Fields{
+		"namespace": svc.Namespace,
+		"service":   svc.Name,
+	})
+
+	fips := sc.FIPc.FloatingIPs()
+
+	sc.allocMu.Lock()
+	defer sc.allocMu.Unlock()
+
+	used := sc.usedIPs(svcKey)
+
+	ip, err := pickServiceIP(svc, fips, used, sc.FIPc.BackendsSynced())
+	if err != nil {
+		sc.Recorder.Event(svc, corev1.EventTypeWarning, "FloatingIPUnavailable", err.Error())
+		return err
+	}
+
+	for allocatedIP, owner := range sc.allocations {
+		if owner == svcKey && allocatedIP != ip {
+			delete(sc.allocations, allocatedIP)
+		}
+	}
+	sc.allocations[ip] = svcKey
+
+	if svc.Annotations[allocatedIPAnnotation] != ip {
+		newSvc := svc.DeepCopy()
+		if newSvc.Annotations == nil {
+			newSvc.Annotations = make(map[string]string)
+		}
+		newSvc.Annotations[allocatedIPAnnotation] = ip
+
+		updateCtx, cancel := fipcontroller.APIContext(ctx)
+		updated, err := sc.K8S.CoreV1().Services(svc.Namespace).Update(updateCtx, newSvc, metav1.UpdateOptions{})
+		cancel()
+		if err != nil {
+			// including conflicts, since we acted on an outdated version; retried via the queue
+			return fmt.Errorf("could not persist allocation: %w", err)
+		}
+		svc = updated
+
+		funcLogger.WithField("fip", ip).Info("allocated floating IP")
+		sc.Recorder.Eventf(svc, corev1.EventTypeNormal, "FloatingIPAllocated", "allocated floating IP %s", ip)
+	}
+
+	if ips := getLoadbalancerIPs(svc); len(ips) != 1 || !ips.Has(ip) {
+		newSvc := svc.DeepCopy()
+		newSvc.Status.LoadBalancer.Ingress = []corev1.LoadBalancerIngress{{IP: ip}}
+
+		updateCtx, cancel := fipcontroller.APIContext(ctx)
+		_, err := sc.K8S.CoreV1().Services(svc.Namespace).UpdateStatus(updateCtx, newSvc, metav1.UpdateOptions{})
+		cancel()
+		if err != nil {
+			return fmt.Errorf("could not update service status: %w", err)
+		}
+
+		funcLogger.WithField("fip", ip).Info("updated service status")
+	}
+
+	return nil
+}
+
+// needsAllocation returns whether the service has no allocated IP yet, or it is not the one published in its status
+func needsAllocation(svc *corev1.Service) bool {
+	allocated := svc.Annotations[allocatedIPAnnotation]
+	if allocated == "" {
+		return true
+	}
+
+	ips := getLoadbalancerIPs(svc)
+	return len(ips) != 1 || !ips.Has(allocated)
+}
+
+// releaseServiceIP forgets the allocation for a deleted service, making its IP available to others
+func (sc *Controller) releaseServiceIP(svcKey string) {
+	sc.allocMu.Lock()
+	defer sc.allocMu.Unlock()
+
+	for ip, owner := range sc.allocations {
+		if owner == svcKey {
+			delete(sc.allocations, ip)
+		}
+	}
+}
+
+// usedIPs returns all IPs in use by services other than svcKey. Since our own updates might not have reached the
+// informer cache yet, this includes allocations we made in the meantime.
+func (sc *Controller) usedIPs(svcKey string) stringset.StringSet {
+	used := make(stringset.StringSet)
+
+	for ip, owner := range sc.allocations {
+		if owner != svcKey {
+			used.Add(ip)
+		}
+	}
+
+	for _, obj := range sc.svcInformerFactory.Core().V1().Services().Informer().GetStore().List() {
+		other, ok := obj.(*corev1.Service)
+		if !ok {
+			continue
+		}
+		if otherKey, err := cache.MetaNamespaceKeyFunc(other); err != nil || otherKey == svcKey {
+			continue
+		}
+		if ip := other.Annotations[allocatedIPAnnotation]; ip != "" {
+			used.Add(ip)
+		}
+		for ip := range getLoadbalancerIPs(other) {
+			used.Add(ip)
+		}
+	}
+
+	return used
+}
+
+// pickServiceIP chooses a floating IP for the service, preferring (in order) the annotation requesting a specific FIP,
+// spec.loadBalancerIP, the previous allocation and finally the first free FIP. Unless complete, fips may lack the IPs of
+// backends which haven't synced yet, so a previous allocation missing from them is not replaced.
+func pickServiceIP(svc *corev1.Service, fips []fipcontroller.FloatingIP, used stringset.StringSet, complete bool) (string, error) {
+	known := make(stringset.StringSet, len(fips))
+	for _, fip := range fips {
+		known.Add(fip.IP)
+	}
+
+	requested := svc.Spec.LoadBalancerIP
+	if annotation := svc.Annotations[floatingIPAnnotation]; annotation != "" {
+		requested = annotation
+		// allow referencing FIPs by name
+		for _, fip := range fips {
+			if fip.Name == annotation {
+				requested = fip.IP
+				break
+			}
+		}
+	}
+
+	if requested != "" {
+		if !known.Has(requested) {
+			return "", fmt.Errorf("requested floating IP %s not found", requested)
+		}
+		if used.Has(requested) {
+			return "", fmt.Errorf("requested floating IP %s already in use", requested)
+		}
+		return requested, nil
+	}
+
+	previous := svc.Annotations[allocatedIPAnnotation]
+	if known.Has(previous) && !used.Has(previous) {
+		return previous, nil
+	}
+	if previous != "" && !known.Has(previous) && !complete {
+		return "", fmt.Errorf("previously allocated floating IP %s not known yet", previous)
+	}
+
+	for _, fip := range fips {
+		if !used.Has(fip.IP) {
+			return fip.IP, nil
+		}
+	}
+
+	return "", fmt.Errorf("no free floating IP available")
+}
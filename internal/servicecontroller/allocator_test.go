@@ -0,0 +1,180 @@
+package servicecontroller
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/costela/hcloud-ip-floater/internal/fipcontroller"
+	"github.com/costela/hcloud-ip-floater/internal/stringset"
+)
+
+func TestPickServiceIP(t *testing.T) {
+	fips := []fipcontroller.FloatingIP{
+		{IP: "10.0.0.1", Name: "one"},
+		{IP: "10.0.0.2", Name: "two"},
+		{IP: "2001:db8::1", Name: "six"},
+	}
+
+	tests := []struct {
+		name           string
+		annotations    map[string]string
+		loadBalancerIP string
+		used           []string
+		incomplete     bool // not all backends synced
+		want           string
+		wantErr        bool
+	}{
+		{
+			name: "first free",
+			want: "10.0.0.1",
+		},
+		{
+			name: "skips used",
+			used: []string{"10.0.0.1"},
+			want: "10.0.0.2",
+		},
+		{
+			name:    "none free",
+			used:    []string{"10.0.0.1", "10.0.0.2", "2001:db8::1"},
+			wantErr: true,
+		},
+		{
+			name:        "annotation by IP",
+			annotations: map[string]string{floatingIPAnnotation: "10.0.0.2"},
+			want:        "10.0.0.2",
+		},
+		{
+			name:        "annotation by name",
+			annotations: map[string]string{floatingIPAnnotation: "six"},
+			want:        "2001:db8::1",
+		},
+		{
+			name:        "annotation not found",
+			annotations: map[string]string{floatingIPAnnotation: "10.0.0.3"},
+			wantErr:     true,
+		},
+		{
+			name:        "annotation in use",
+			annotations: map[string]string{floatingIPAnnotation: "two"},
+			used:        []string{"10.0.0.2"},
+			wantErr:     true,
+		},
+		{
+			name:           "annotation takes precedence over loadBalancerIP",
+			annotations:    map[string]string{floatingIPAnnotation: "10.0.0.2"},
+			loadBalancerIP: "10.0.0.1",
+			want:           "10.0.0.2",
+		},
+		{
+			name:           "loadBalancerIP",
+			loadBalancerIP: "10.0.0.2",
+			want:           "10.0.0.2",
+		},
+		{
+			name:           "loadBalancerIP not found",
+			loadBalancerIP: "10.0.0.3",
+			wantErr:        true,
+		},
+		{
+			name:           "requested IP does not fall back to previous allocation",
+			annotations:    map[string]string{allocatedIPAnnotation: "10.0.0.1"},
+			loadBalancerIP: "10.0.0.3",
+			wantErr:        true,
+		},
+		{
+			name:        "previous allocation",
+			annotations: map[string]string{allocatedIPAnnotation: "10.0.0.2"},
+			want:        "10.0.0.2",
+		},
+		{
+			name:        "previous allocation in use",
+			annotations: map[string]string{allocatedIPAnnotation: "10.0.0.1"},
+			used:        []string{"10.0.0.1"},
+			want:        "10.0.0.2",
+		},
+		{
+			name:        "previous allocation gone",
+			annotations: map[string]string{allocatedIPAnnotation: "10.0.0.3"},
+			want:        "10.0.0.1",
+		},
+		{
+			name:        "previous allocation not known yet",
+			annotations: map[string]string{allocatedIPAnnotation: "10.0.0.3"},
+			incomplete:  true,
+			wantErr:     true,
+		},
+		{
+			name:        "previous allocation known while incomplete",
+			annotations: map[string]string{allocatedIPAnnotation: "10.0.0.2"},
+			incomplete:  true,
+			want:        "10.0.0.2",
+		},
+		{
+			name:       "first free while incomplete",
+			incomplete: true,
+			want:       "10.0.0.1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := &corev1.Service{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "svc", Annotations: tt.annotations},
+				Spec:       corev1.ServiceSpec{LoadBalancerIP: tt.loadBalancerIP},
+			}
+
+			used := make(stringset.StringSet)
+			for _, ip := range tt.used {
+				used.Add(ip)
+			}
+
+			got, err := pickServiceIP(svc, fips, used, !tt.incomplete)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got %s", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNeedsAllocation(t *testing.T) {
+	tests := []struct {
+		name      string
+		allocated string
+		ips       []string
+		want      bool
+	}{
+		{"nothing", "", nil, true},
+		{"published only", "", []string{"10.0.0.1"}, true},
+		{"allocated only", "10.0.0.1", nil, true},
+		{"allocated and published", "10.0.0.1", []string{"10.0.0.1"}, false},
+		{"other IP published", "10.0.0.1", []string{"10.0.0.2"}, true},
+		{"additional IP published", "10.0.0.1", []string{"10.0.0.1", "10.0.0.2"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "svc"}}
+			if tt.allocated != "" {
+				svc.Annotations = map[string]string{allocatedIPAnnotation: tt.allocated}
+			}
+			for _, ip := range tt.ips {
+				svc.Status.LoadBalancer.Ingress = append(svc.Status.LoadBalancer.Ingress, corev1.LoadBalancerIngress{IP: ip})
+			}
+
+			if got := needsAllocation(svc); got != tt.want {
+				t.Errorf("got %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,42 @@
+package servicecontroller
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// annotations overriding the global damping settings for a service, in seconds
+const (
+	readyHoldDownAnnotation = "hcloud-ip-floater.cstl.dev/ready-hold-down"
+	moveCooldownAnnotation  = "hcloud-ip-floater.cstl.dev/move-cooldown"
+)
+
+// dampingSetting returns the duration configured for the service via annotation, falling back to the global setting
+func (sc *Controller) dampingSetting(svc *corev1.Service, annotation string, globalSeconds int) time.Duration {
+	if value, ok := svc.Annotations[annotation]; ok {
+		seconds, err := strconv.Atoi(value)
+		if err == nil && seconds >= 0 {
+			return time.Duration(seconds) * time.Second
+		}
+		sc.Logger.WithFields(logrus.Fields{
+			"namespace": svc.Namespace,
+			"service":   svc.Name,
+			"value":     value,
+		}).Warnf("ignoring invalid %s annotation", annotation)
+	}
+
+	return time.Duration(globalSeconds) * time.Second
+}
+
+// podReadySince returns since when the pod has been continuously ready
+func podReadySince(pod *corev1.Pod) (time.Time, bool) {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodReady && condition.Status == corev1.ConditionTrue {
+			return condition.LastTransitionTime.Time, true
+		}
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,213 @@
+package servicecontroller
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+
+	"github.com/costela/hcloud-ip-floater/internal/config"
+	"github.com/costela/hcloud-ip-floater/internal/metrics"
+	"github.com/costela/hcloud-ip-floater/internal/stringset"
+)
+
+const (
+	// stickyAnnotation overrides the global stickiness policy for a service
+	stickyAnnotation = "hcloud-ip-floater.cstl.dev/sticky"
+	// preferredNodesAnnotation lists nodes in order of preference; other nodes are only used if none of them is
+	// eligible
+	preferredNodesAnnotation = "hcloud-ip-floater.cstl.dev/preferred-nodes"
+	// pinnedNodeAnnotation attaches the service's IPs to the given node, regardless of its pods or eligibility
+	pinnedNodeAnnotation = "hcloud-ip-floater.cstl.dev/pinned-node"
+)
+
+// electNode chooses the node the service's IPs should be attached to, out of the nodes with ready pods (one entry per
+// pod). Returns false if the service's election policy rules out all of them.
+func (sc *Controller) electNode(svc *corev1.Service, svcKey string, svcIPs stringset.StringSet, nodes []string) (string, bool) {
+	pods := make(map[string]int, len(nodes))
+	candidates := make([]string, 0, len(nodes))
+	for _, node := range nodes {
+		if pods[node] == 0 {
+			candidates = append(candidates, node)
+		}
+		pods[node]++
+	}
+
+	// the hash order is the tie-breaker for the election policy
+	hashSortNodes(candidates, svcKey)
+
+	current, hasCurrent := sc.currentNode(svcIPs, candidates)
+
+	filtered, err := sc.applyElectionPolicy(svc, candidates, pods, current)
+	if err != nil {
+		sc.reportPolicyError(svc, err)
+	}
+	if filtered != nil {
+		candidates = filtered
+	}
+
+	if len(candidates) == 0 {
+		sc.Logger.WithFields(logrus.Fields{
+			"namespace": svc.Namespace,
+			"service":   svc.Name,
+		}).Info("election policy matches no nodes with ready pods")
+		sc.Recorder.Event(svc, corev1.EventTypeWarning, "NoMatchingNodes", "election policy matches no nodes with ready pods")
+		return "", false
+	}
+
+	preferred := preferredNodes(svc)
+	sortByPreference(candidates, preferred)
+
+	// the current node may have been filtered out by the policy
+	hasCurrent = hasCurrent && containsString(candidates, current)
+
+	// after a restart, keep IPs where the previous leader put them instead of re-electing from scratch
+	if hasCurrent && sc.adopting {
+		sc.Logger.WithFields(logrus.Fields{
+			"namespace": svc.Namespace,
+			"service":   svc.Name,
+			"node":      current,
+		}).Info("adopting existing attachment")
+		metrics.StartupAttachments.WithLabelValues("adopted").Add(float64(len(svcIPs)))
+		return current, true
+	}
+
+	// stickiness must not keep IPs away from a more preferred node
+	if hasCurrent && sc.isSticky(svc) && preferenceRank(preferred, current) <= preferenceRank(preferred, candidates[0]) {
+		return current, true
+	}
+
+	elected := candidates[0]
+
+	// don't move IPs around too often, unless their current node is no longer eligible
+	cooldown := sc.dampingSetting(svc, moveCooldownAnnotation, config.Global.MoveCooldownSeconds)
+	if hasCurrent && current != elected && cooldown > 0 {
+		if remaining := cooldown - time.Since(sc.FIPc.LastMove(svcIPs)); remaining > 0 {
+			sc.Logger.WithFields(logrus.Fields{
+				"namespace": svc.Namespace,
+				"service":   svc.Name,
+				"node":      elected,
+				"remaining": remaining,
+			}).Info("postponing move during cooldown")
+			sc.queue.AddAfter(svcKey, remaining)
+			return current, true
+		}
+	}
+
+	if sc.adopting {
+		sc.reportStartupMove(svc, svcIPs, elected)
+	}
+
+	return elected, true
+}
+
+// reportStartupMove logs and counts service IPs found attached to a node that could not be adopted
+func (sc *Controller) reportStartupMove(svc *corev1.Service, svcIPs stringset.StringSet, elected string) {
+	nodes, err := sc.nodeInformerFactory.Core().V1().Nodes().Lister().List(labels.Everything())
+	if err != nil {
+		return
+	}
+
+	current, found := sc.FIPc.CurrentNode(svcIPs, nodes)
+	if !found || current == elected {
+		return
+	}
+
+	sc.Logger.WithFields(logrus.Fields{
+		"namespace": svc.Namespace,
+		"service":   svc.Name,
+		"from":      current,
+		"node":      elected,
+	}).Info("not adopting existing attachment")
+	metrics.StartupAttachments.WithLabelValues("moved").Add(float64(len(svcIPs)))
+}
+
+// preferredNodes returns the service's preferred nodes, in order
+func preferredNodes(svc *corev1.Service) []string {
+	value := svc.Annotations[preferredNodesAnnotation]
+	if value == "" {
+		return nil
+	}
+
+	preferred := make([]string, 0)
+	for _, node := range strings.Split(value, ",") {
+		if node = strings.TrimSpace(node); node != "" {
+			preferred = append(preferred, node)
+		}
+	}
+	return preferred
+}
+
+// preferenceRank returns the position of the node in the preference list, or its length for all other nodes
+func preferenceRank(preferred []string, node string) int {
+	for i, p := range preferred {
+		if p == node {
+			return i
+		}
+	}
+	return len(preferred)
+}
+
+// sortByPreference moves preferred nodes to the front, keeping the order of all others
+func sortByPreference(nodes []string, preferred []string) {
+	if len(preferred) == 0 {
+		return
+	}
+
+	sort.SliceStable(nodes, func(i, j int) bool {
+		return preferenceRank(preferred, nodes[i]) < preferenceRank(preferred, nodes[j])
+	})
+}
+
+// pinnedNode returns the node the service is pinned to, if any
+func pinnedNode(svc *corev1.Service) (string, bool) {
+	node := strings.TrimSpace(svc.Annotations[pinnedNodeAnnotation])
+	return node, node != ""
+}
+
+// hashSortNodes orders nodes by hash of node#service, the same way MetalLB does.
+// This means we will pick the same node MetalLB does so services with externalTrafficPolicy=Local work correctly
+func hashSortNodes(nodes []string, svcKey string) {
+	sort.Slice(nodes, func(i, j int) bool {
+		hi := sha256.Sum256([]byte(nodes[i] + "#" + svcKey))
+		hj := sha256.Sum256([]byte(nodes[j] + "#" + svcKey))
+
+		return bytes.Compare(hi[:], hj[:]) < 0
+	})
+}
+
+// isSticky returns whether the service's IPs should stay where they are as long as that node has ready pods
+func (sc *Controller) isSticky(svc *corev1.Service) bool {
+	if value, ok := svc.Annotations[stickyAnnotation]; ok {
+		sticky, err := strconv.ParseBool(value)
+		if err == nil {
+			return sticky
+		}
+		sc.Logger.WithFields(logrus.Fields{
+			"namespace": svc.Namespace,
+			"service":   svc.Name,
+		}).WithError(err).Warnf("ignoring invalid %s annotation", stickyAnnotation)
+	}
+
+	return config.Global.StickyAttachments
+}
+
+// currentNode returns the node out of the given ones that the service IPs are currently attached to
+func (sc *Controller) currentNode(svcIPs stringset.StringSet, nodes []string) (string, bool) {
+	candidates := make([]*corev1.Node, 0, len(nodes))
+	for _, name := range nodes {
+		node, err := sc.nodeInformerFactory.Core().V1().Nodes().Lister().Get(name)
+		if err != nil {
+			continue
+		}
+		candidates = append(candidates, node)
+	}
+
+	return sc.FIPc.CurrentNode(svcIPs, candidates)
+}
@@ -0,0 +1,263 @@
+package servicecontroller
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/costela/hcloud-ip-floater/internal/config"
+	"github.com/costela/hcloud-ip-floater/internal/metrics"
+)
+
+const testSvcKey = "default/svc"
+
+// newElectionTestController returns a controller knowing the given nodes, without running any informers
+func newElectionTestController(t *testing.T, fipc *mockFIPController, nodes ...*corev1.Node) *Controller {
+	t.Helper()
+
+	sc := &Controller{
+		Logger:              newTestLogger(),
+		FIPc:                fipc,
+		Recorder:            record.NewFakeRecorder(100),
+		nodeInformerFactory: informers.NewSharedInformerFactory(fake.NewSimpleClientset(), 0),
+		queue:               newServiceQueue(),
+	}
+	t.Cleanup(sc.queue.ShutDown)
+
+	indexer := sc.nodeInformerFactory.Core().V1().Nodes().Informer().GetIndexer()
+	for _, node := range nodes {
+		if err := indexer.Add(node); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return sc
+}
+
+func testNode(name string, labels map[string]string) *corev1.Node {
+	return &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels}}
+}
+
+func testService(annotations map[string]string) *corev1.Service {
+	return &corev1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "svc", Annotations: annotations}}
+}
+
+// hashOrder returns the nodes in the order the election considers them, absent any policy or preference
+func hashOrder(nodes ...string) []string {
+	sorted := append([]string{}, nodes...)
+	hashSortNodes(sorted, testSvcKey)
+	return sorted
+}
+
+func TestElectNodeSticky(t *testing.T) {
+	order := hashOrder("a", "b")
+	winner, other := order[0], order[1]
+
+	tests := []struct {
+		name        string
+		global      bool
+		annotations map[string]string
+		current     string // empty if not attached to any candidate
+		want        string
+	}{
+		{"not sticky", false, nil, other, winner},
+		{"sticky", true, nil, other, other},
+		{"sticky without current node", true, nil, "", winner},
+		{"sticky with current node gone", true, nil, "gone", winner},
+		{"annotation enables", false, map[string]string{stickyAnnotation: "true"}, other, other},
+		{"annotation disables", true, map[string]string{stickyAnnotation: "false"}, other, winner},
+		{"invalid annotation", true, map[string]string{stickyAnnotation: "sometimes"}, other, other},
+	}
+
+	saved := config.Global
+	t.Cleanup(func() { config.Global = saved })
+	config.Global.MoveCooldownSeconds = 0
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config.Global.StickyAttachments = tt.global
+
+			sc := newElectionTestController(t, &mockFIPController{current: tt.current}, testNode("a", nil), testNode("b", nil))
+
+			got, ok := sc.electNode(testService(tt.annotations), testSvcKey, newStringSet("192.0.2.1"), []string{"a", "b"})
+			if !ok {
+				t.Fatal("expected a node to be elected")
+			}
+			if got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestElectNodeCooldown(t *testing.T) {
+	order := hashOrder("a", "b")
+	winner, other := order[0], order[1]
+
+	tests := []struct {
+		name        string
+		global      int // seconds
+		annotations map[string]string
+		current     string
+		lastMove    time.Duration // ago
+		want        string
+	}{
+		{"no cooldown", 0, nil, other, time.Second, winner},
+		{"recent move", 60, nil, other, time.Second, other},
+		{"old move", 60, nil, other, 2 * time.Minute, winner},
+		{"never moved", 60, nil, other, -1, winner},
+		{"current node gone", 60, nil, "gone", time.Second, winner},
+		{"already on elected node", 60, nil, winner, time.Second, winner},
+		{"annotation enables", 0, map[string]string{moveCooldownAnnotation: "60"}, other, time.Second, other},
+		{"annotation disables", 60, map[string]string{moveCooldownAnnotation: "0"}, other, time.Second, winner},
+		{"invalid annotation", 60, map[string]string{moveCooldownAnnotation: "-1"}, other, time.Second, other},
+	}
+
+	saved := config.Global
+	t.Cleanup(func() { config.Global = saved })
+	config.Global.StickyAttachments = false
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config.Global.MoveCooldownSeconds = tt.global
+
+			fipc := &mockFIPController{current: tt.current}
+			if tt.lastMove >= 0 {
+				fipc.lastMove = time.Now().Add(-tt.lastMove)
+			}
+			sc := newElectionTestController(t, fipc, testNode("a", nil), testNode("b", nil))
+
+			got, ok := sc.electNode(testService(tt.annotations), testSvcKey, newStringSet("192.0.2.1"), []string{"a", "b"})
+			if !ok {
+				t.Fatal("expected a node to be elected")
+			}
+			if got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestElectNodePreferred(t *testing.T) {
+	order := hashOrder("a", "b")
+	winner, other := order[0], order[1]
+
+	tests := []struct {
+		name      string
+		preferred string
+		sticky    bool
+		current   string
+		want      string
+	}{
+		{"no preference", "", false, "", winner},
+		{"preferred", other, false, "", other},
+		{"first eligible preference", "gone, " + other + ", " + winner, false, "", other},
+		{"no preferred node eligible", "gone", false, "", winner},
+		{"blank entries", " , ", false, "", winner},
+		{"sticky on preferred node", winner + "," + other, true, other, winner},
+		{"sticky among unpreferred nodes", "gone", true, other, other},
+		{"sticky on equally preferred node", "", true, other, other},
+	}
+
+	saved := config.Global
+	t.Cleanup(func() { config.Global = saved })
+	config.Global.StickyAttachments = false
+	config.Global.MoveCooldownSeconds = 0
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			annotations := map[string]string{stickyAnnotation: strconv.FormatBool(tt.sticky)}
+			if tt.preferred != "" {
+				annotations[preferredNodesAnnotation] = tt.preferred
+			}
+
+			sc := newElectionTestController(t, &mockFIPController{current: tt.current}, testNode("a", nil), testNode("b", nil))
+
+			got, ok := sc.electNode(testService(annotations), testSvcKey, newStringSet("192.0.2.1"), []string{"a", "b"})
+			if !ok {
+				t.Fatal("expected a node to be elected")
+			}
+			if got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPinnedNode(t *testing.T) {
+	tests := []struct {
+		annotations map[string]string
+		want        string
+		wantOK      bool
+	}{
+		{nil, "", false},
+		{map[string]string{pinnedNodeAnnotation: ""}, "", false},
+		{map[string]string{pinnedNodeAnnotation: " "}, "", false},
+		{map[string]string{pinnedNodeAnnotation: " node-a "}, "node-a", true},
+	}
+
+	for _, tt := range tests {
+		got, ok := pinnedNode(testService(tt.annotations))
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("%v: got %q (%t), want %q (%t)", tt.annotations, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestElectNodeAdoption(t *testing.T) {
+	order := hashOrder("a", "b")
+	winner, other := order[0], order[1]
+
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		current     string // "c" has no ready pods
+		want        string
+		wantAdopted float64
+		wantMoved   float64
+	}{
+		{"adopted", nil, other, other, 2, 0},
+		{"adopted over preference", map[string]string{preferredNodesAnnotation: winner}, other, other, 2, 0},
+		{"already on elected node", nil, winner, winner, 2, 0},
+		{"filtered out by policy", map[string]string{electionFilterAnnotation: `!node.current`}, other, winner, 0, 2},
+		{"node without pods", nil, "c", winner, 0, 2},
+		{"not attached", nil, "", winner, 0, 0},
+	}
+
+	saved := config.Global
+	t.Cleanup(func() { config.Global = saved })
+	config.Global.StickyAttachments = false
+	config.Global.MoveCooldownSeconds = 0
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc := newElectionTestController(t, &mockFIPController{current: tt.current}, testNode("a", nil), testNode("b", nil), testNode("c", nil))
+			sc.adopting = true
+
+			adopted := testutil.ToFloat64(metrics.StartupAttachments.WithLabelValues("adopted"))
+			moved := testutil.ToFloat64(metrics.StartupAttachments.WithLabelValues("moved"))
+
+			got, ok := sc.electNode(testService(tt.annotations), testSvcKey, newStringSet("192.0.2.1", "192.0.2.2"), []string{"a", "b"})
+			if !ok {
+				t.Fatal("expected a node to be elected")
+			}
+			if got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+
+			if got := testutil.ToFloat64(metrics.StartupAttachments.WithLabelValues("adopted")) - adopted; got != tt.wantAdopted {
+				t.Errorf("got %v adopted IPs, want %v", got, tt.wantAdopted)
+			}
+			if got := testutil.ToFloat64(metrics.StartupAttachments.WithLabelValues("moved")) - moved; got != tt.wantMoved {
+				t.Errorf("got %v moved IPs, want %v", got, tt.wantMoved)
+			}
+		})
+	}
+}
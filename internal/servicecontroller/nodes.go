@@ -0,0 +1,213 @@
+package servicecontroller
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/cache"
+)
+
+// nodeSelectorAnnotation restricts the nodes a service's IPs may be attached to, in addition to the global selector
+const nodeSelectorAnnotation = "hcloud-ip-floater.cstl.dev/node-selector"
+
+// addNodeInformer watches nodes, so we can fail over as soon as a node stops being ready, instead of waiting for its
+// pods to be evicted.
+func (sc *Controller) addNodeInformer() cache.SharedIndexInformer {
+	nodeInformer := sc.nodeInformerFactory.Core().V1().Nodes().Informer()
+
+	nodeInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldNode, ok := oldObj.(*corev1.Node)
+			if !ok {
+				sc.Logger.Errorf("received unexpected old object type: %T", oldObj)
+				return
+			}
+			newNode, ok := newObj.(*corev1.Node)
+			if !ok {
+				sc.Logger.Errorf("received unexpected new object type: %T", newObj)
+				return
+			}
+
+			oldReady := nodeIsReady(oldNode)
+			newReady := nodeIsReady(newNode)
+
+			if oldReady != newReady {
+				if oldReady {
+					sc.Logger.WithField("node", newNode.Name).Info("node became not-ready")
+				} else {
+					sc.Logger.WithField("node", newNode.Name).Info("node became ready")
+				}
+			} else if !reflect.DeepEqual(oldNode.Labels, newNode.Labels) || !reflect.DeepEqual(oldNode.Spec.Taints, newNode.Spec.Taints) {
+				// labels and taints may change the node's eligibility for some or all services
+				sc.Logger.WithField("node", newNode.Name).Info("node labels or taints changed")
+			} else {
+				return
+			}
+
+			sc.handleNodeChange(newNode.Name)
+		},
+		DeleteFunc: func(oldObj interface{}) {
+			if tombstone, ok := oldObj.(cache.DeletedFinalStateUnknown); ok {
+				oldObj = tombstone.Obj
+			}
+			oldNode, ok := oldObj.(*corev1.Node)
+			if !ok {
+				sc.Logger.Errorf("received unexpected old object type: %T", oldObj)
+				return
+			}
+
+			sc.Logger.WithField("node", oldNode.Name).Info("node deleted")
+
+			sc.handleNodeChange(oldNode.Name)
+		},
+	})
+
+	return nodeInformer
+}
+
+// handleNodeChange re-elects nodes for all services with pods scheduled on the given node.
+func (sc *Controller) handleNodeChange(nodeName string) {
+	sc.podInformersMu.RLock()
+	affected := make([]string, 0)
+	for svcKey, podInformer := range sc.podInformers {
+		// LabelSelector comes from the podInformerFactory
+		pods, err := podInformer.factory.Core().V1().Pods().Lister().List(labels.NewSelector())
+		if err != nil {
+			sc.Logger.WithError(err).WithField("service", svcKey).Error("could not list pods")
+			continue
+		}
+		for _, pod := range pods {
+			if pod.Spec.NodeName == nodeName {
+				affected = append(affected, svcKey)
+				break
+			}
+		}
+	}
+	sc.podInformersMu.RUnlock()
+
+	for _, svcKey := range affected {
+		svc, err := sc.getServiceFromKey(svcKey)
+		if err != nil {
+			sc.Logger.WithError(err).WithField("service", svcKey).Error("could not get service")
+			continue
+		}
+
+		sc.Logger.WithFields(logrus.Fields{
+			"namespace": svc.Namespace,
+			"service":   svc.Name,
+			"node":      nodeName,
+		}).Info("re-electing node for service")
+
+		sc.queue.Add(svcKey)
+	}
+}
+
+// nodeIsEligible returns whether FIPs may be attached to the given node; i.e. it still exists, is ready, matches both
+// the global and the service's node selector and has none of the excluded taints
+func (sc *Controller) nodeIsEligible(nodeName string, svcSelector labels.Selector) bool {
+	node, err := sc.nodeInformerFactory.Core().V1().Nodes().Lister().Get(nodeName)
+	if err != nil {
+		return false
+	}
+
+	if !sc.nodeSelector.Matches(labels.Set(node.Labels)) || !svcSelector.Matches(labels.Set(node.Labels)) {
+		return false
+	}
+
+	for _, taint := range node.Spec.Taints {
+		for _, excluded := range sc.excludedTaints {
+			if excluded.matches(taint) {
+				return false
+			}
+		}
+	}
+
+	return nodeIsReady(node)
+}
+
+// serviceNodeSelector returns the node selector from the service's annotation. Invalid selectors are reported and
+// ignored.
+func (sc *Controller) serviceNodeSelector(svc *corev1.Service) labels.Selector {
+	value, ok := svc.Annotations[nodeSelectorAnnotation]
+	if !ok {
+		return labels.Everything()
+	}
+
+	selector, err := labels.Parse(value)
+	if err != nil {
+		sc.Logger.WithFields(logrus.Fields{
+			"namespace": svc.Namespace,
+			"service":   svc.Name,
+		}).WithError(err).Warnf("ignoring invalid %s annotation", nodeSelectorAnnotation)
+		sc.Recorder.Eventf(svc, corev1.EventTypeWarning, "InvalidNodeSelector", "ignoring invalid node selector %q: %s", value, err)
+		return labels.Everything()
+	}
+
+	return selector
+}
+
+// taintMatcher matches node taints by key and, optionally, value and effect
+type taintMatcher struct {
+	key    string
+	value  *string
+	effect corev1.TaintEffect
+}
+
+func (tm taintMatcher) matches(taint corev1.Taint) bool {
+	if tm.key != "" && tm.key != taint.Key {
+		return false
+	}
+	if tm.value != nil && *tm.value != taint.Value {
+		return false
+	}
+	return tm.effect == "" || tm.effect == taint.Effect
+}
+
+// ParseExcludedTaints parses taints in the form key[=value][:effect] or :effect
+func ParseExcludedTaints(specs []string) ([]taintMatcher, error) {
+	matchers := make([]taintMatcher, 0, len(specs))
+	for _, spec := range specs {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+
+		var tm taintMatcher
+		if i := strings.LastIndex(spec, ":"); i >= 0 {
+			tm.effect = corev1.TaintEffect(spec[i+1:])
+			spec = spec[:i]
+			switch tm.effect {
+			case corev1.TaintEffectNoSchedule, corev1.TaintEffectPreferNoSchedule, corev1.TaintEffectNoExecute:
+			default:
+				return nil, fmt.Errorf("invalid taint effect %q", tm.effect)
+			}
+		}
+		if i := strings.Index(spec, "="); i >= 0 {
+			value := spec[i+1:]
+			tm.value = &value
+			spec = spec[:i]
+		}
+		tm.key = spec
+
+		if tm.key == "" && tm.effect == "" {
+			return nil, fmt.Errorf("invalid taint %q", spec)
+		}
+
+		matchers = append(matchers, tm)
+	}
+
+	return matchers, nil
+}
+
+func nodeIsReady(node *corev1.Node) bool {
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == corev1.NodeReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
@@ -0,0 +1,208 @@
+package servicecontroller
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+func TestParseExcludedTaints(t *testing.T) {
+	strPtr := func(s string) *string { return &s }
+
+	tests := []struct {
+		name    string
+		specs   []string
+		want    []taintMatcher
+		wantErr bool
+	}{
+		{
+			name:  "empty",
+			specs: nil,
+			want:  []taintMatcher{},
+		},
+		{
+			name:  "blank entries are skipped",
+			specs: []string{"", "  "},
+			want:  []taintMatcher{},
+		},
+		{
+			name:  "key only",
+			specs: []string{"node.kubernetes.io/unschedulable"},
+			want:  []taintMatcher{{key: "node.kubernetes.io/unschedulable"}},
+		},
+		{
+			name:  "key and value",
+			specs: []string{"dedicated=db"},
+			want:  []taintMatcher{{key: "dedicated", value: strPtr("db")}},
+		},
+		{
+			name:  "empty value",
+			specs: []string{"dedicated="},
+			want:  []taintMatcher{{key: "dedicated", value: strPtr("")}},
+		},
+		{
+			name:  "key, value and effect",
+			specs: []string{" dedicated=db:NoSchedule "},
+			want:  []taintMatcher{{key: "dedicated", value: strPtr("db"), effect: corev1.TaintEffectNoSchedule}},
+		},
+		{
+			name:  "key and effect",
+			specs: []string{"dedicated:NoExecute"},
+			want:  []taintMatcher{{key: "dedicated", effect: corev1.TaintEffectNoExecute}},
+		},
+		{
+			name:  "effect only",
+			specs: []string{":PreferNoSchedule"},
+			want:  []taintMatcher{{effect: corev1.TaintEffectPreferNoSchedule}},
+		},
+		{
+			name:  "several",
+			specs: []string{"a", "b=c"},
+			want:  []taintMatcher{{key: "a"}, {key: "b", value: strPtr("c")}},
+		},
+		{
+			name:    "invalid effect",
+			specs:   []string{"dedicated:Sometimes"},
+			wantErr: true,
+		},
+		{
+			name:    "missing effect",
+			specs:   []string{"dedicated:"},
+			wantErr: true,
+		},
+		{
+			name:    "neither key nor effect",
+			specs:   []string{"=db"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseExcludedTaints(tt.specs)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got %+v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d matchers, want %d", len(got), len(tt.want))
+			}
+			for i := range got {
+				if !taintMatcherEquals(got[i], tt.want[i]) {
+					t.Errorf("matcher %d: got %s, want %s", i, formatTaintMatcher(got[i]), formatTaintMatcher(tt.want[i]))
+				}
+			}
+		})
+	}
+}
+
+func TestTaintMatcherMatches(t *testing.T) {
+	strPtr := func(s string) *string { return &s }
+
+	taint := corev1.Taint{Key: "dedicated", Value: "db", Effect: corev1.TaintEffectNoSchedule}
+
+	tests := []struct {
+		name    string
+		matcher taintMatcher
+		want    bool
+	}{
+		{"key", taintMatcher{key: "dedicated"}, true},
+		{"other key", taintMatcher{key: "other"}, false},
+		{"key and value", taintMatcher{key: "dedicated", value: strPtr("db")}, true},
+		{"other value", taintMatcher{key: "dedicated", value: strPtr("web")}, false},
+		{"empty value", taintMatcher{key: "dedicated", value: strPtr("")}, false},
+		{"key and effect", taintMatcher{key: "dedicated", effect: corev1.TaintEffectNoSchedule}, true},
+		{"other effect", taintMatcher{key: "dedicated", effect: corev1.TaintEffectNoExecute}, false},
+		{"effect only", taintMatcher{effect: corev1.TaintEffectNoSchedule}, true},
+		{"other effect only", taintMatcher{effect: corev1.TaintEffectPreferNoSchedule}, false},
+		{"everything", taintMatcher{key: "dedicated", value: strPtr("db"), effect: corev1.TaintEffectNoSchedule}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.matcher.matches(taint); got != tt.want {
+				t.Errorf("matches(%+v) = %t, want %t", taint, got, tt.want)
+			}
+		})
+	}
+}
+
+func taintMatcherEquals(a, b taintMatcher) bool {
+	if a.key != b.key || a.effect != b.effect {
+		return false
+	}
+	if a.value == nil || b.value == nil {
+		return a.value == b.value
+	}
+	return *a.value == *b.value
+}
+
+func formatTaintMatcher(tm taintMatcher) string {
+	s := tm.key
+	if tm.value != nil {
+		s += "=" + *tm.value
+	}
+	if tm.effect != "" {
+		s += ":" + string(tm.effect)
+	}
+	return s
+}
+
+func TestNodeIsEligible(t *testing.T) {
+	ready := corev1.NodeStatus{Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionTrue}}}
+	notReady := corev1.NodeStatus{Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionFalse}}}
+
+	node := func(name string, labels map[string]string, status corev1.NodeStatus, taints ...corev1.Taint) *corev1.Node {
+		n := testNode(name, labels)
+		n.Spec.Taints = taints
+		n.Status = status
+		return n
+	}
+
+	sc := newElectionTestController(t, &mockFIPController{},
+		node("ready", map[string]string{"role": "edge", "zone": "a"}, ready),
+		node("not-ready", map[string]string{"role": "edge"}, notReady),
+		node("unknown", map[string]string{"role": "edge"}, corev1.NodeStatus{}),
+		node("other-role", map[string]string{"role": "worker"}, ready),
+		node("tainted", map[string]string{"role": "edge"}, ready, corev1.Taint{Key: "dedicated", Value: "db", Effect: corev1.TaintEffectNoSchedule}),
+		node("other-taint", map[string]string{"role": "edge"}, ready, corev1.Taint{Key: "dedicated", Value: "web", Effect: corev1.TaintEffectNoSchedule}),
+	)
+	sc.nodeSelector = labels.SelectorFromSet(labels.Set{"role": "edge"})
+
+	var err error
+	sc.excludedTaints, err = ParseExcludedTaints([]string{"dedicated=db"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		node        string
+		svcSelector labels.Selector
+		want        bool
+	}{
+		{"ready", labels.Everything(), true},
+		{"ready", labels.SelectorFromSet(labels.Set{"zone": "a"}), true},
+		{"ready", labels.SelectorFromSet(labels.Set{"zone": "b"}), false},
+		{"not-ready", labels.Everything(), false},
+		{"unknown", labels.Everything(), false},
+		{"other-role", labels.Everything(), false},
+		{"tainted", labels.Everything(), false},
+		{"other-taint", labels.Everything(), true},
+		{"gone", labels.Everything(), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.node+" "+tt.svcSelector.String(), func(t *testing.T) {
+			if got := sc.nodeIsEligible(tt.node, tt.svcSelector); got != tt.want {
+				t.Errorf("got %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
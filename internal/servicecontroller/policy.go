@@ -0,0 +1,180 @@
+package servicecontroller
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+
+	"github.com/google/cel-go/cel"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/costela/hcloud-ip-floater/internal/metrics"
+)
+
+// annotations holding CEL expressions evaluated for each candidate node. Expressions see a `node` variable with the
+// fields `name`, `labels`, `pods` (number of ready pods of the service) and `current` (whether the service's IPs are
+// currently attached to it).
+const (
+	// electionFilterAnnotation must evaluate to a bool; nodes for which it is false are not considered
+	electionFilterAnnotation = "hcloud-ip-floater.cstl.dev/election-filter"
+	// electionScoreAnnotation must evaluate to a number; nodes with higher scores are preferred
+	electionScoreAnnotation = "hcloud-ip-floater.cstl.dev/election-score"
+)
+
+// celCostLimit bounds the evaluation cost of a single expression, since they come from annotations anyone allowed to
+// edit services can set
+const celCostLimit = 10000
+
+var celEnv = func() *cel.Env {
+	env, err := cel.NewEnv(cel.Variable("node", cel.MapType(cel.StringType, cel.DynType)))
+	if err != nil {
+		panic(err)
+	}
+	return env
+}()
+
+// maxCompiledPrograms bounds the cache of compiled expressions; every expression ever set on a service would otherwise
+// stay in it
+const maxCompiledPrograms = 1000
+
+// compiledPrograms caches CEL programs by expression, since annotations rarely change
+var (
+	compiledPrograms   = make(map[string]compiledProgram)
+	compiledProgramsMu sync.Mutex
+)
+
+type compiledProgram struct {
+	program cel.Program
+	err     error
+}
+
+func compileExpression(expr string) (cel.Program, error) {
+	compiledProgramsMu.Lock()
+	defer compiledProgramsMu.Unlock()
+
+	if cached, ok := compiledPrograms[expr]; ok {
+		return cached.program, cached.err
+	}
+
+	var compiled compiledProgram
+	ast, iss := celEnv.Compile(expr)
+	if iss.Err() != nil {
+		compiled.err = iss.Err()
+	} else {
+		compiled.program, compiled.err = celEnv.Program(ast, cel.CostLimit(celCostLimit))
+	}
+
+	if len(compiledPrograms) >= maxCompiledPrograms {
+		// expressions still in use are simply compiled again
+		compiledPrograms = make(map[string]compiledProgram)
+	}
+	compiledPrograms[expr] = compiled
+
+	return compiled.program, compiled.err
+}
+
+// applyElectionPolicy filters and orders the hash-sorted nodes according to the service's CEL expressions. Nodes with
+// equal scores keep their hash order. Invalid expressions return no nodes and an error, so the policy is ignored.
+// Expressions failing for single nodes are reported as an error too, but the policy still applies: nodes for which the
+// filter fails are excluded, and those for which the score fails get the lowest score.
+func (sc *Controller) applyElectionPolicy(svc *corev1.Service, nodes []string, pods map[string]int, current string) ([]string, error) {
+	filterExpr, hasFilter := svc.Annotations[electionFilterAnnotation]
+	scoreExpr, hasScore := svc.Annotations[electionScoreAnnotation]
+	if !hasFilter && !hasScore {
+		return nodes, nil
+	}
+
+	var filter, score cel.Program
+	var err error
+	if hasFilter {
+		if filter, err = compileExpression(filterExpr); err != nil {
+			return nil, fmt.Errorf("invalid %s annotation: %w", electionFilterAnnotation, err)
+		}
+	}
+	if hasScore {
+		if score, err = compileExpression(scoreExpr); err != nil {
+			return nil, fmt.Errorf("invalid %s annotation: %w", electionScoreAnnotation, err)
+		}
+	}
+
+	candidates := make([]string, 0, len(nodes))
+	scores := make(map[string]float64, len(nodes))
+
+	// problems evaluating the expressions for single nodes are reported, but don't disable the whole policy
+	var evalErr error
+
+	for _, name := range nodes {
+		var nodeLabels map[string]string
+		if node, err := sc.nodeInformerFactory.Core().V1().Nodes().Lister().Get(name); err == nil {
+			nodeLabels = node.Labels
+		}
+		if nodeLabels == nil {
+			nodeLabels = map[string]string{}
+		}
+
+		vars := map[string]interface{}{
+			"node": map[string]interface{}{
+				"name":    name,
+				"labels":  nodeLabels,
+				"pods":    pods[name],
+				"current": name == current,
+			},
+		}
+
+		if filter != nil {
+			// a filter that cannot be evaluated (e.g. due to missing labels or typos) must not let the node through
+			out, _, err := filter.Eval(vars)
+			if err != nil {
+				evalErr = fmt.Errorf("could not evaluate %s annotation for node %s: %w", electionFilterAnnotation, name, err)
+				continue
+			}
+			if keep, ok := out.Value().(bool); !ok {
+				evalErr = fmt.Errorf("%s annotation must evaluate to a bool, got %T", electionFilterAnnotation, out.Value())
+				continue
+			} else if !keep {
+				continue
+			}
+		}
+
+		if score != nil {
+			// nodes whose score cannot be evaluated get the lowest one
+			out, _, err := score.Eval(vars)
+			if err != nil {
+				evalErr = fmt.Errorf("could not evaluate %s annotation for node %s: %w", electionScoreAnnotation, name, err)
+				scores[name] = math.Inf(-1)
+			} else {
+				switch value := out.Value().(type) {
+				case int64:
+					scores[name] = float64(value)
+				case uint64:
+					scores[name] = float64(value)
+				case float64:
+					scores[name] = value
+				default:
+					evalErr = fmt.Errorf("%s annotation must evaluate to a number, got %T", electionScoreAnnotation, out.Value())
+					scores[name] = math.Inf(-1)
+				}
+			}
+		}
+
+		candidates = append(candidates, name)
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return scores[candidates[i]] > scores[candidates[j]]
+	})
+
+	return candidates, evalErr
+}
+
+// reportPolicyError makes invalid election policies visible on the service, instead of silently ignoring them
+func (sc *Controller) reportPolicyError(svc *corev1.Service, err error) {
+	sc.Logger.WithFields(logrus.Fields{
+		"namespace": svc.Namespace,
+		"service":   svc.Name,
+	}).WithError(err).Warn("invalid election policy")
+	sc.Recorder.Eventf(svc, corev1.EventTypeWarning, "InvalidElectionPolicy", "invalid election policy: %s", err)
+	metrics.ElectionPolicyErrors.WithLabelValues(svc.Namespace, svc.Name).Inc()
+}
@@ -0,0 +1,179 @@
+package servicecontroller
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"k8s.io/client-go/tools/record"
+)
+
+func TestApplyElectionPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		filter  string // empty for none
+		score   string // empty for none
+		want    []string
+		wantErr bool
+	}{
+		{
+			name: "no policy",
+			want: []string{"a", "b", "c"},
+		},
+		{
+			name:   "filter by label",
+			filter: `has(node.labels.zone) && node.labels.zone != "fsn1"`,
+			want:   []string{"b"},
+		},
+		{
+			name:   "filter by current node",
+			filter: `!node.current`,
+			want:   []string{"a", "c"},
+		},
+		{
+			name:    "filter failing for some nodes",
+			filter:  `node.labels.zone == "fsn1"`,
+			want:    []string{"a"},
+			wantErr: true,
+		},
+		{
+			name:    "filter not returning a bool",
+			filter:  `node.pods`,
+			want:    []string{},
+			wantErr: true,
+		},
+		{
+			name:    "invalid filter",
+			filter:  `node.pods >`,
+			wantErr: true,
+		},
+		{
+			name:  "score",
+			score: `node.pods`,
+			want:  []string{"b", "c", "a"},
+		},
+		{
+			name:  "score ties keep order",
+			score: `node.current ? 1.5 : 0.0`,
+			want:  []string{"b", "a", "c"},
+		},
+		{
+			name:    "score failing for some nodes",
+			score:   `node.labels.zone == "nbg1" ? 1 : 0`,
+			want:    []string{"b", "a", "c"},
+			wantErr: true,
+		},
+		{
+			name:    "score not returning a number",
+			score:   `node.name`,
+			want:    []string{"a", "b", "c"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid score",
+			score:   `node.pods +`,
+			wantErr: true,
+		},
+		{
+			name:   "filter and score",
+			filter: `node.pods > 1`,
+			score:  `-node.pods`,
+			want:   []string{"c", "b"},
+		},
+	}
+
+	sc := newElectionTestController(t, &mockFIPController{},
+		testNode("a", map[string]string{"zone": "fsn1"}),
+		testNode("b", map[string]string{"zone": "nbg1"}),
+		testNode("c", nil),
+	)
+	pods := map[string]int{"a": 1, "b": 3, "c": 2}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			annotations := make(map[string]string)
+			if tt.filter != "" {
+				annotations[electionFilterAnnotation] = tt.filter
+			}
+			if tt.score != "" {
+				annotations[electionScoreAnnotation] = tt.score
+			}
+
+			got, err := sc.applyElectionPolicy(testService(annotations), []string{"a", "b", "c"}, pods, "b")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want error %t", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestElectNodePolicy(t *testing.T) {
+	order := hashOrder("a", "b")
+
+	tests := []struct {
+		name      string
+		filter    string
+		want      string
+		wantOK    bool
+		wantEvent string // reason, empty for none
+	}{
+		{"filter", `node.name == "` + order[1] + `"`, order[1], true, ""},
+		{"no match", `false`, "", false, "NoMatchingNodes"},
+		{"invalid policy is ignored", `node.pods >`, order[0], true, "InvalidElectionPolicy"},
+		{"failing filter still applies", `node.labels.zone == "fsn1"`, "", false, "InvalidElectionPolicy"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc := newElectionTestController(t, &mockFIPController{}, testNode("a", nil), testNode("b", nil))
+
+			got, ok := sc.electNode(testService(map[string]string{electionFilterAnnotation: tt.filter}), testSvcKey, newStringSet("192.0.2.1"), []string{"a", "b"})
+			if ok != tt.wantOK || got != tt.want {
+				t.Errorf("got %q (%t), want %q (%t)", got, ok, tt.want, tt.wantOK)
+			}
+
+			events := sc.Recorder.(*record.FakeRecorder).Events
+			var event string
+			select {
+			case event = <-events:
+			default:
+			}
+			if !strings.Contains(event, tt.wantEvent) || (tt.wantEvent == "") != (event == "") {
+				t.Errorf("got event %q, want reason %q", event, tt.wantEvent)
+			}
+		})
+	}
+}
+
+func TestCompileExpressionCache(t *testing.T) {
+	first, err := compileExpression(`node.pods > 0`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := compileExpression(`node.pods > 0`); again != first {
+		t.Error("expected cached program")
+	}
+
+	if _, err := compileExpression(`node.pods >`); err == nil {
+		t.Error("expected error")
+	}
+	if _, err := compileExpression(`node.pods >`); err == nil {
+		t.Error("expected cached error")
+	}
+
+	for i := 0; i <= maxCompiledPrograms; i++ {
+		if _, err := compileExpression(fmt.Sprintf("node.pods > %d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	compiledProgramsMu.Lock()
+	defer compiledProgramsMu.Unlock()
+	if len(compiledPrograms) > maxCompiledPrograms {
+		t.Errorf("got %d cached programs, want at most %d", len(compiledPrograms), maxCompiledPrograms)
+	}
+}
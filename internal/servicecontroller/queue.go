@@ -0,0 +1,147 @@
+package servicecontroller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+
+	"github.com/costela/hcloud-ip-floater/internal/config"
+)
+
+// bounds for the exponential backoff of failed service syncs
+const (
+	retryBaseDelay = time.Second
+	retryMaxDelay  = 5 * time.Minute
+)
+
+func newServiceQueue() workqueue.RateLimitingInterface {
+	return workqueue.NewRateLimitingQueue(workqueue.NewItemExponentialFailureRateLimiter(retryBaseDelay, retryMaxDelay))
+}
+
+// enqueueService schedules an election for the service. Keys already waiting in the queue are only processed once, so
+// bursts of events for the same service are merged.
+func (sc *Controller) enqueueService(svc *corev1.Service) error {
+	svcKey, err := cache.MetaNamespaceKeyFunc(svc)
+	if err != nil {
+		return err
+	}
+
+	sc.queue.Add(svcKey)
+	return nil
+}
+
+// initialSync handles every given service once, using the configured number of workers, before events are processed.
+// Failed services are retried through the queue.
+func (sc *Controller) initialSync(ctx context.Context, svcKeys []string) {
+	sc.Logger.WithField("services", len(svcKeys)).Info("starting initial reconciliation")
+	start := time.Now()
+
+	sc.adopting = config.Global.AdoptAttachments
+	defer func() { sc.adopting = false }()
+
+	keys := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < config.Global.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for svcKey := range keys {
+				if err := sc.syncService(ctx, svcKey); err != nil && ctx.Err() == nil {
+					sc.Logger.WithError(err).WithField("service", svcKey).Error("could not handle service; retrying")
+					sc.queue.AddRateLimited(svcKey)
+				}
+			}
+		}()
+	}
+
+feed:
+	for _, svcKey := range svcKeys {
+		select {
+		case keys <- svcKey:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(keys)
+	wg.Wait()
+
+	sc.Logger.WithField("duration", time.Since(start).Round(time.Millisecond)).Info("initial reconciliation complete")
+}
+
+func (sc *Controller) runWorker(ctx context.Context) {
+	for sc.processNextItem(ctx) {
+	}
+}
+
+func (sc *Controller) processNextItem(ctx context.Context) bool {
+	key, quit := sc.queue.Get()
+	if quit {
+		return false
+	}
+	defer sc.queue.Done(key)
+
+	// the queue is drained on shutdown; don't start new work
+	if ctx.Err() != nil {
+		return false
+	}
+
+	svcKey := key.(string)
+
+	if err := sc.syncService(ctx, svcKey); err != nil {
+		if ctx.Err() != nil {
+			return false
+		}
+		sc.Logger.WithError(err).WithFields(logrus.Fields{
+			"service": svcKey,
+			"retries": sc.queue.NumRequeues(key),
+		}).Error("could not handle service; retrying")
+		sc.queue.AddRateLimited(key)
+		return true
+	}
+
+	sc.queue.Forget(key)
+	return true
+}
+
+// syncService allocates an IP for the service if needed, elects a node for it and attaches its IPs to it
+func (sc *Controller) syncService(ctx context.Context, svcKey string) error {
+	obj, exists, err := sc.svcInformerFactory.Core().V1().Services().Informer().GetIndexer().GetByKey(svcKey)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		// deletions are handled by the informer
+		return nil
+	}
+
+	svc, ok := obj.(*corev1.Service)
+	if !ok {
+		sc.Logger.Errorf("got unexpected obj type %T", obj)
+		return nil
+	}
+
+	// keys scheduled for later (e.g. hold-downs or retries) are not dropped when we stop handling a service
+	if sc.unsupportedServiceType(svc) {
+		return nil
+	}
+
+	// allocation updates the service, so it happens here instead of in the event handlers. Its IPs are handled anyway,
+	// e.g. a service whose requested IP is not available may still have a working one.
+	allocErr := sc.allocateServiceIP(ctx, svc)
+	if allocErr != nil {
+		allocErr = fmt.Errorf("could not allocate service IP: %w", allocErr)
+	}
+
+	if _, pinned := pinnedNode(svc); !pinned && !sc.hasPodInformer(svc) {
+		return allocErr
+	}
+
+	return errors.Join(allocErr, sc.handleServiceIPs(ctx, svc, getLoadbalancerIPs(svc)))
+}
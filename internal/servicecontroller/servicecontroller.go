@@ -1,13 +1,13 @@
 package servicecontroller
 
 import (
-	"bytes"
-	"crypto/sha256"
+	"context"
 	"fmt"
-	"sort"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/sirupsen/logrus"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -15,31 +15,79 @@ import (
 	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
 
 	"github.com/costela/hcloud-ip-floater/internal/config"
 	"github.com/costela/hcloud-ip-floater/internal/fipcontroller"
+	"github.com/costela/hcloud-ip-floater/internal/metrics"
 	"github.com/costela/hcloud-ip-floater/internal/stringset"
 )
 
+// policies for LoadBalancer services without spec.loadBalancerClass
+const (
+	UnclassedClaim    = "claim"     // handle them as if they had our class
+	UnclassedIgnore   = "ignore"    // leave them to other implementations
+	UnclassedKnownFIP = "known-fip" // only handle them if they already use one of our floating IPs
+)
+
+// annotationPrefix is shared by all service annotations influencing the controller
+const annotationPrefix = "hcloud-ip-floater.cstl.dev/"
+
+// fipController wraps a thin interface around the fipcontroller.Controller to make it more easily mockable
+type fipController interface {
+	Synced() bool
+	BackendsSynced() bool
+	FloatingIPs() []fipcontroller.FloatingIP
+	KnownIP(ip string) bool
+	SharedNode(svc *corev1.Service, svcIPs stringset.StringSet) (string, bool)
+	CurrentNode(svcIPs stringset.StringSet, candidates []*corev1.Node) (string, bool)
+	LastMove(svcIPs stringset.StringSet) time.Time
+	AttachToNode(ctx context.Context, svc *corev1.Service, svcIPs stringset.StringSet, node *corev1.Node) error
+	ForgetAttachments(svcIPs stringset.StringSet)
+}
+
 type podInformerType struct {
 	factory informers.SharedInformerFactory
 	stopper chan struct{}
 }
 
 type Controller struct {
-	Logger logrus.FieldLogger
-	K8S    *kubernetes.Clientset
-	FIPc   *fipcontroller.Controller
+	Logger   logrus.FieldLogger
+	K8S      *kubernetes.Clientset
+	FIPc     fipController
+	Recorder record.EventRecorder
+
+	// Elected is closed once this replica may make changes, e.g. after winning the leader election. Until then, all
+	// caches are kept in sync, but services are not handled.
+	Elected <-chan struct{}
 
 	svcIPs   map[string]stringset.StringSet
 	svcIPsMu sync.RWMutex
 
-	svcInformerFactory informers.SharedInformerFactory
-	podInformers       map[string]podInformerType
-	podInformersMu     sync.RWMutex
+	allocations map[string]string // allocated IP to service key
+	allocMu     sync.Mutex
+
+	svcInformerFactory  informers.SharedInformerFactory
+	nodeInformerFactory informers.SharedInformerFactory
+	podInformers        map[string]podInformerType
+	podInformersMu      sync.RWMutex
+
+	// keys of services needing an election
+	queue workqueue.RateLimitingInterface
+
+	nodeSelector   labels.Selector
+	excludedTaints []taintMatcher
+
+	// adopting is set during the initial reconciliation, when existing attachments are kept if possible
+	adopting bool
 }
 
-func (sc *Controller) Run() {
+// Run watches services, pods and nodes until ctx is done, handling services once Elected is closed. On shutdown it lets
+// workers finish their current service and stops all informers before returning.
+func (sc *Controller) Run(ctx context.Context) {
+	// podInformersMu also guards initialization against concurrent HasSynced calls
+	sc.podInformersMu.Lock()
 	sc.svcInformerFactory = informers.NewSharedInformerFactoryWithOptions(
 		sc.K8S,
 		time.Duration(config.Global.SyncSeconds)*time.Second,
@@ -47,15 +95,34 @@ func (sc *Controller) Run() {
 			listOpts.LabelSelector = config.Global.ServiceLabelSelector
 		}),
 	)
+	sc.nodeInformerFactory = informers.NewSharedInformerFactory(sc.K8S, time.Duration(config.Global.SyncSeconds)*time.Second)
 	sc.svcIPs = make(map[string]stringset.StringSet)
+	sc.allocations = make(map[string]string)
 	sc.podInformers = make(map[string]podInformerType)
+	sc.queue = newServiceQueue()
+	sc.podInformersMu.Unlock()
+
+	// both validated on startup
+	sc.nodeSelector, _ = labels.Parse(config.Global.NodeLabelSelector)
+	sc.excludedTaints, _ = ParseExcludedTaints(config.Global.ExcludedTaints)
+
+	stopper := ctx.Done()
+	defer sc.stopPodInformers()
+
+	// node eligibility is checked during election, so we need a complete picture of nodes before handling services
+	nodeInformer := sc.addNodeInformer()
+	go nodeInformer.Run(stopper)
+	if !cache.WaitForCacheSync(stopper, nodeInformer.HasSynced) {
+		sc.Logger.Error("could not sync node informer")
+		return
+	}
 
 	svcInformer := sc.svcInformerFactory.Core().V1().Services().Informer()
-	stopper := make(chan struct{})
-	defer close(stopper)
 
-	svcInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+	svcHandler, err := svcInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
 		AddFunc: func(newObj interface{}) {
+			metrics.WatchedServices.Set(float64(len(svcInformer.GetStore().ListKeys())))
+
 			newSvc, ok := newObj.(*corev1.Service)
 			if !ok {
 				sc.Logger.Errorf("received unexpected object type: %T", newObj)
@@ -67,6 +134,12 @@ func (sc *Controller) Run() {
 			if err := sc.handleServiceAdd(newSvc); err != nil {
 				sc.Logger.WithError(err).Error("error handling new service")
 			}
+			// services without ready pods need an IP as well, so they can't wait for the pod informer
+			if config.Global.AllocateIPs {
+				if err := sc.enqueueService(newSvc); err != nil {
+					sc.Logger.WithError(err).Error("could not enqueue service")
+				}
+			}
 		},
 		UpdateFunc: func(oldObj, newObj interface{}) {
 			oldSvc, ok := oldObj.(*corev1.Service)
@@ -80,6 +153,22 @@ func (sc *Controller) Run() {
 				return
 			}
 			if sc.unsupportedServiceType(newSvc) {
+				// e.g. its class changed, or it no longer uses one of our floating IPs
+				if sc.hasPodInformer(newSvc) {
+					sc.handleServiceRemoval(newSvc)
+				}
+				return
+			}
+			if config.Global.AllocateIPs && (needsAllocation(newSvc) || oldSvc.Spec.LoadBalancerIP != newSvc.Spec.LoadBalancerIP) {
+				if err := sc.enqueueService(newSvc); err != nil {
+					sc.Logger.WithError(err).Error("could not enqueue service")
+				}
+			}
+			if !sc.hasPodInformer(newSvc) {
+				// services can become supported after creation, e.g. once MetalLB assigned one of our floating IPs
+				if err := sc.handleServiceAdd(newSvc); err != nil {
+					sc.Logger.WithError(err).Error("error handling new service")
+				}
 				return
 			}
 			if err := sc.handleServiceUpdate(oldSvc, newSvc); err != nil {
@@ -87,26 +176,111 @@ func (sc *Controller) Run() {
 			}
 		},
 		DeleteFunc: func(oldObj interface{}) {
+			metrics.WatchedServices.Set(float64(len(svcInformer.GetStore().ListKeys())))
+
 			oldSvc, ok := oldObj.(*corev1.Service)
 			if !ok {
 				sc.Logger.Errorf("received unexpected old object type: %T", oldObj)
 				return
 			}
-			if sc.unsupportedServiceType(oldSvc) {
-				return
-			}
-			svcKey, err := cache.MetaNamespaceKeyFunc(oldSvc)
-			if err != nil {
-				return
-			}
-			sc.forgetServiceIPs(svcKey)
-			if err := sc.removePodInformer(oldSvc); err != nil {
-				sc.Logger.WithError(err).Error("error removing pod informer")
-			}
+			// whether it was supported may have changed since we last saw it; cleaning up unknown services is a no-op
+			sc.handleServiceRemoval(oldSvc)
 		},
 	})
+	if err != nil {
+		sc.Logger.WithError(err).Error("could not watch services")
+		return
+	}
+
+	var workers sync.WaitGroup
+	defer workers.Wait()
+	defer sc.queue.ShutDown()
+
+	go svcInformer.Run(stopper)
+
+	// electing with incomplete caches could move IPs away from nodes whose pods we just haven't seen yet. Pod informers
+	// are added by the service handler, so they are only all known once it received the initial services.
+	sc.Logger.Info("waiting for caches to sync")
+	if !cache.WaitForCacheSync(stopper, svcHandler.HasSynced, sc.HasSynced, sc.FIPc.Synced) {
+		sc.Logger.Error("could not sync caches")
+		return
+	}
+
+	// with --unclassed-services=known-fip, services are only recognized as supported once FIPs are known, which usually
+	// isn't the case yet when the service handler first sees them
+	for _, obj := range svcInformer.GetStore().List() {
+		svc, ok := obj.(*corev1.Service)
+		if !ok || sc.unsupportedServiceType(svc) || sc.hasPodInformer(svc) {
+			continue
+		}
+		if err := sc.handleServiceAdd(svc); err != nil {
+			sc.Logger.WithError(err).Error("error handling new service")
+		}
+	}
+	if !cache.WaitForCacheSync(stopper, sc.HasSynced) {
+		sc.Logger.Error("could not sync pod informers")
+		return
+	}
+
+	sc.Logger.Info("waiting for leadership")
+	select {
+	case <-sc.Elected:
+	case <-stopper:
+		return
+	}
+
+	var svcKeys []string
+	for _, obj := range svcInformer.GetStore().List() {
+		svc, ok := obj.(*corev1.Service)
+		if !ok || sc.unsupportedServiceType(svc) {
+			continue
+		}
 
-	svcInformer.Run(stopper)
+		svcKey, err := cache.MetaNamespaceKeyFunc(svc)
+		if err != nil {
+			continue
+		}
+		svcKeys = append(svcKeys, svcKey)
+	}
+
+	sc.initialSync(ctx, svcKeys)
+
+	// hcloud calls happen in the workers, so a slow API doesn't block event delivery
+	for i := 0; i < config.Global.Workers; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			sc.runWorker(ctx)
+		}()
+	}
+
+	<-stopper
+}
+
+// HasSynced returns whether the service informer and all per-service pod informers have completed their initial sync.
+func (sc *Controller) HasSynced() bool {
+	sc.podInformersMu.RLock()
+	defer sc.podInformersMu.RUnlock()
+
+	if sc.svcInformerFactory == nil {
+		return false
+	}
+
+	if !sc.nodeInformerFactory.Core().V1().Nodes().Informer().HasSynced() {
+		return false
+	}
+
+	if !sc.svcInformerFactory.Core().V1().Services().Informer().HasSynced() {
+		return false
+	}
+
+	for _, podInformer := range sc.podInformers {
+		if !podInformer.factory.Core().V1().Pods().Informer().HasSynced() {
+			return false
+		}
+	}
+
+	return true
 }
 
 func (sc *Controller) handleServiceAdd(svc *corev1.Service) error {
@@ -119,6 +293,21 @@ func (sc *Controller) handleServiceAdd(svc *corev1.Service) error {
 	return sc.addPodInformer(svc)
 }
 
+// handleServiceRemoval stops handling a service that was deleted or is no longer supported
+func (sc *Controller) handleServiceRemoval(svc *corev1.Service) {
+	svcKey, err := cache.MetaNamespaceKeyFunc(svc)
+	if err != nil {
+		return
+	}
+	sc.queue.Forget(svcKey)
+	sc.forgetServiceIPs(svcKey)
+	sc.releaseServiceIP(svcKey)
+	metrics.PinnedServices.DeletePartialMatch(prometheus.Labels{"namespace": svc.Namespace, "service": svc.Name})
+	if err := sc.removePodInformer(svc); err != nil {
+		sc.Logger.WithError(err).Error("error removing pod informer")
+	}
+}
+
 func (sc *Controller) handleServiceUpdate(oldSvc, newSvc *corev1.Service) error {
 	sc.Logger.WithFields(logrus.Fields{
 		"namespace": newSvc.Namespace,
@@ -129,12 +318,12 @@ func (sc *Controller) handleServiceUpdate(oldSvc, newSvc *corev1.Service) error
 	newIPs := getLoadbalancerIPs(newSvc)
 
 	if len(oldIPs) != len(newIPs) {
-		return sc.handleServiceIPs(newSvc, newIPs)
+		return sc.enqueueService(newSvc)
 	}
 
 	for i := range oldIPs {
 		if !newIPs.Has(i) {
-			return sc.handleServiceIPs(newSvc, newIPs)
+			return sc.enqueueService(newSvc)
 		}
 	}
 
@@ -142,6 +331,11 @@ func (sc *Controller) handleServiceUpdate(oldSvc, newSvc *corev1.Service) error
 		return sc.replacePodInformer(oldSvc, newSvc)
 	}
 
+	// our annotations influence the election, so changing them must take effect right away
+	if annotationsChanged(oldSvc, newSvc) {
+		return sc.enqueueService(newSvc)
+	}
+
 	sc.Logger.WithFields(logrus.Fields{
 		"namespace": newSvc.Namespace,
 		"service":   newSvc.Name,
@@ -207,16 +401,35 @@ func (sc *Controller) addPodInformer(svc *corev1.Service) error {
 	sc.podInformersMu.Lock()
 	defer sc.podInformersMu.Unlock()
 
+	if _, ok := sc.podInformers[svcKey]; ok {
+		// e.g. added by the service handler while the initial sync added it too
+		return nil
+	}
+
 	sc.podInformers[svcKey] = podInformerType{
 		factory: podInformerFactory,
 		stopper: stopper,
 	}
+	metrics.PodInformers.Set(float64(len(sc.podInformers)))
 
 	go podInformer.Run(stopper)
 
 	return nil
 }
 
+func (sc *Controller) hasPodInformer(svc *corev1.Service) bool {
+	svcKey, err := cache.MetaNamespaceKeyFunc(svc)
+	if err != nil {
+		return false
+	}
+
+	sc.podInformersMu.RLock()
+	defer sc.podInformersMu.RUnlock()
+
+	_, ok := sc.podInformers[svcKey]
+	return ok
+}
+
 func (sc *Controller) removePodInformer(svc *corev1.Service) error {
 	svcKey, err := cache.MetaNamespaceKeyFunc(svc)
 	if err != nil {
@@ -238,10 +451,23 @@ func (sc *Controller) removePodInformer(svc *corev1.Service) error {
 
 	delete(sc.podInformers, svcKey)
 	close(podInformer.stopper)
+	metrics.PodInformers.Set(float64(len(sc.podInformers)))
 
 	return nil
 }
 
+// stopPodInformers stops the pod informers of all services on shutdown
+func (sc *Controller) stopPodInformers() {
+	sc.podInformersMu.Lock()
+	defer sc.podInformersMu.Unlock()
+
+	for svcKey, podInformer := range sc.podInformers {
+		delete(sc.podInformers, svcKey)
+		close(podInformer.stopper)
+	}
+	metrics.PodInformers.Set(0)
+}
+
 func (sc *Controller) replacePodInformer(oldSvc, newSvc *corev1.Service) error {
 	// TODO: too simple: we might miss events between remove/add; should fetch old/replace/close
 	if err := sc.removePodInformer(oldSvc); err != nil {
@@ -272,8 +498,8 @@ func (sc *Controller) handleNewPod(svcKey string, newPod *corev1.Pod) error {
 		return nil
 	}
 
-	ips := getLoadbalancerIPs(svc)
-	return sc.handleServiceIPs(svc, ips)
+	sc.queue.Add(svcKey)
+	return nil
 }
 
 func (sc *Controller) handlePodUpdate(svcKey string, oldPod, newPod *corev1.Pod) error {
@@ -302,8 +528,8 @@ func (sc *Controller) handlePodUpdate(svcKey string, oldPod, newPod *corev1.Pod)
 		return nil // some other uninteresting state transition
 	}
 
-	ips := getLoadbalancerIPs(svc)
-	return sc.handleServiceIPs(svc, ips)
+	sc.queue.Add(svcKey)
+	return nil
 }
 
 func (sc *Controller) getServiceFromKey(svcKey string) (*corev1.Service, error) {
@@ -329,9 +555,7 @@ func podIsReady(pod *corev1.Pod) bool {
 	return false
 }
 
-func (sc *Controller) handleServiceIPs(svc *corev1.Service, svcIPs stringset.StringSet) error {
-	// TODO: use util/workqueue to avoid blocking informer if hcloud API is slow
-
+func (sc *Controller) handleServiceIPs(ctx context.Context, svc *corev1.Service, svcIPs stringset.StringSet) error {
 	svcKey, err := cache.MetaNamespaceKeyFunc(svc)
 	if err != nil {
 		return err
@@ -347,69 +571,156 @@ func (sc *Controller) handleServiceIPs(svc *corev1.Service, svcIPs stringset.Str
 		return nil
 	}
 
-	nodes, err := sc.getServiceReadyNodes(svcKey)
+	if pinned, ok := pinnedNode(svc); ok {
+		return sc.attachToPinnedNode(ctx, svc, svcIPs, pinned)
+	}
+	metrics.PinnedServices.DeletePartialMatch(prometheus.Labels{"namespace": svc.Namespace, "service": svc.Name})
+
+	holdDown := sc.dampingSetting(svc, readyHoldDownAnnotation, config.Global.ReadyHoldDownSeconds)
+	nodes, wait, err := sc.getServiceReadyNodes(svcKey, holdDown, sc.serviceNodeSelector(svc))
 	if err != nil {
 		return err
 	}
 
-	if len(nodes) == 0 {
+	if wait > 0 {
+		// some pods only recently became ready; check again once they are past the hold-down
+		sc.queue.AddAfter(svcKey, wait)
+	}
+
+	if len(nodes) == 0 && wait > 0 {
+		sc.Logger.WithFields(logrus.Fields{
+			"namespace": svc.Namespace,
+			"service":   svc.Name,
+		}).Info("service pods not ready long enough")
+		return nil
+	} else if len(nodes) == 0 {
 		sc.Logger.WithFields(logrus.Fields{
 			"namespace": svc.Namespace,
 			"service":   svc.Name,
 		}).Info("service has no ready pods")
+		sc.Recorder.Event(svc, corev1.EventTypeWarning, "NoReadyPods", "no ready pods to attach floating IPs to")
+		return nil
+	}
+
+	elected, ok := sc.electNode(svc, svcKey, svcIPs, nodes)
+	if !ok {
 		return nil
 	}
 
-	// Order ready nodes by hash of node#service, the same way MetalLB does
-	// This means we will pick the same node MetalLB does so services with externalTrafficPolicy=Local work correctly
-	sort.Slice(nodes, func(i, j int) bool {
-		hi := sha256.Sum256([]byte(nodes[i] + "#" + svcKey))
-		hj := sha256.Sum256([]byte(nodes[j] + "#" + svcKey))
+	// services using addresses from the same IPv6 FIP must be kept on the same node
+	if sharedNode, shared := sc.FIPc.SharedNode(svc, svcIPs); shared && sharedNode != elected {
+		if containsString(nodes, sharedNode) {
+			elected = sharedNode
+		} else {
+			sc.Logger.WithFields(logrus.Fields{
+				"namespace": svc.Namespace,
+				"service":   svc.Name,
+				"node":      sharedNode,
+			}).Warn("service shares floating IP with services on a node without ready pods")
+			sc.Recorder.Eventf(svc, corev1.EventTypeWarning, "FloatingIPShared", "floating IP is shared with services on node %s, which has no ready pods of this service", sharedNode)
+		}
+	}
+
+	electedNode, err := sc.nodeInformerFactory.Core().V1().Nodes().Lister().Get(elected)
+	if err != nil {
+		return err
+	}
+
+	return sc.FIPc.AttachToNode(ctx, svc, svcIPs, electedNode)
+}
 
-		return bytes.Compare(hi[:], hj[:]) < 0
+// attachToPinnedNode attaches the service's IPs to the node it is pinned to, bypassing the election. This is meant for
+// maintenance, so it does not care about the node's pods or eligibility.
+func (sc *Controller) attachToPinnedNode(ctx context.Context, svc *corev1.Service, svcIPs stringset.StringSet, pinned string) error {
+	funcLogger := sc.Logger.WithFields(logrus.Fields{
+		"namespace": svc.Namespace,
+		"service":   svc.Name,
+		"node":      pinned,
 	})
 
-	electedNode := nodes[0]
+	node, err := sc.nodeInformerFactory.Core().V1().Nodes().Lister().Get(pinned)
+	if err != nil {
+		funcLogger.WithError(err).Warn("could not find pinned node")
+		sc.Recorder.Eventf(svc, corev1.EventTypeWarning, "PinnedNodeNotFound", "could not find pinned node %s", pinned)
+		return nil
+	}
 
-	sc.FIPc.AttachToNode(svcIPs, electedNode)
-	return nil
+	funcLogger.Info("using pinned node (manual override)")
+	metrics.PinnedServices.DeletePartialMatch(prometheus.Labels{"namespace": svc.Namespace, "service": svc.Name})
+	metrics.PinnedServices.WithLabelValues(svc.Namespace, svc.Name, pinned).Set(1)
+
+	return sc.FIPc.AttachToNode(ctx, svc, svcIPs, node)
 }
 
-// getServiceReadyNodes gets all nodes where ready pods are scheduled
-func (sc *Controller) getServiceReadyNodes(svcKey string) ([]string, error) {
+// getServiceReadyNodes gets all eligible nodes where pods have been ready for at least holdDown. It also returns how
+// long until the next pod still in its hold-down becomes eligible, or 0 if there is none.
+func (sc *Controller) getServiceReadyNodes(svcKey string, holdDown time.Duration, svcSelector labels.Selector) ([]string, time.Duration, error) {
 	sc.podInformersMu.RLock()
 	podInformerFactory, ok := sc.podInformers[svcKey]
 	sc.podInformersMu.RUnlock()
 
 	if !ok {
-		return nil, fmt.Errorf("could not find informer factory for svc %s", svcKey)
+		return nil, 0, fmt.Errorf("could not find informer factory for svc %s", svcKey)
 	}
 
 	// LabelSelector comes from the podInformerFactory
 	pods, err := podInformerFactory.factory.Core().V1().Pods().Lister().List(labels.NewSelector())
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 
+	var wait time.Duration
 	nodes := make([]string, 0, len(pods))
 	for _, pod := range pods {
-		if podIsReady(pod) {
-			nodes = append(nodes, pod.Spec.NodeName)
+		readySince, ready := podReadySince(pod)
+		if !ready || !sc.nodeIsEligible(pod.Spec.NodeName, svcSelector) {
+			continue
+		}
+		if remaining := holdDown - time.Since(readySince); remaining > 0 {
+			if wait == 0 || remaining < wait {
+				wait = remaining
+			}
+			continue
 		}
+		nodes = append(nodes, pod.Spec.NodeName)
 	}
 
-	return nodes, nil
+	return nodes, wait, nil
 }
 
 func (sc *Controller) unsupportedServiceType(svc *corev1.Service) bool {
+	funcLogger := sc.Logger.WithFields(logrus.Fields{
+		"namespace": svc.Namespace,
+		"service":   svc.Name,
+	})
+
 	if svc.Spec.Type != corev1.ServiceTypeLoadBalancer {
-		sc.Logger.WithFields(logrus.Fields{
-			"namespace": svc.Namespace,
-			"service":   svc.Name,
-		}).Info("skipping non-LoadBalancer service")
+		funcLogger.Info("skipping non-LoadBalancer service")
+		return true
+	}
+
+	if svc.Spec.LoadBalancerClass != nil {
+		if *svc.Spec.LoadBalancerClass != config.Global.LoadBalancerClass {
+			funcLogger.WithField("class", *svc.Spec.LoadBalancerClass).Info("skipping service with foreign load balancer class")
+			return true
+		}
+		return false
+	}
 
+	switch config.Global.UnclassedServices {
+	case UnclassedIgnore:
+		funcLogger.Info("skipping service without load balancer class")
+		return true
+	case UnclassedKnownFIP:
+		for ip := range getLoadbalancerIPs(svc) {
+			if sc.FIPc.KnownIP(ip) {
+				return false
+			}
+		}
+		funcLogger.Info("skipping service without load balancer class not using a known floating IP")
 		return true
 	}
+
 	return false
 }
 
@@ -419,6 +730,13 @@ func (sc *Controller) updateServiceIPs(svcKey string, svcIPs stringset.StringSet
 
 	oldIPs := sc.svcIPs[svcKey]
 	sc.FIPc.ForgetAttachments(oldIPs.Diff(svcIPs))
+
+	// remembered so the attachments can be forgotten once the service is gone
+	if len(svcIPs) == 0 {
+		delete(sc.svcIPs, svcKey)
+	} else {
+		sc.svcIPs[svcKey] = svcIPs
+	}
 }
 
 func (sc *Controller) forgetServiceIPs(svcKey string) {
@@ -430,6 +748,21 @@ func (sc *Controller) forgetServiceIPs(svcKey string) {
 	delete(sc.svcIPs, svcKey)
 }
 
+// annotationsChanged returns whether any of our annotations differ between both versions of the service
+func annotationsChanged(oldSvc, newSvc *corev1.Service) bool {
+	for key, value := range oldSvc.Annotations {
+		if newValue, ok := newSvc.Annotations[key]; strings.HasPrefix(key, annotationPrefix) && (!ok || newValue != value) {
+			return true
+		}
+	}
+	for key := range newSvc.Annotations {
+		if _, ok := oldSvc.Annotations[key]; strings.HasPrefix(key, annotationPrefix) && !ok {
+			return true
+		}
+	}
+	return false
+}
+
 func getLoadbalancerIPs(svc *corev1.Service) stringset.StringSet {
 	ips := make(stringset.StringSet, len(svc.Status.LoadBalancer.Ingress))
 
@@ -442,3 +775,12 @@ func getLoadbalancerIPs(svc *corev1.Service) stringset.StringSet {
 	}
 	return ips
 }
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,227 @@
+package servicecontroller
+
+import (
+	"context"
+	"io"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/costela/hcloud-ip-floater/internal/config"
+	"github.com/costela/hcloud-ip-floater/internal/fipcontroller"
+	"github.com/costela/hcloud-ip-floater/internal/stringset"
+)
+
+// mockFIPController answers the service controller's questions from fixed state and records forgotten attachments
+type mockFIPController struct {
+	known     stringset.StringSet // IPs reported as known
+	current   string              // node the service IPs are currently attached to, if any
+	lastMove  time.Time
+	forgotten stringset.StringSet
+}
+
+func (m *mockFIPController) Synced() bool         { return true }
+func (m *mockFIPController) BackendsSynced() bool { return true }
+
+func (m *mockFIPController) FloatingIPs() []fipcontroller.FloatingIP {
+	return nil
+}
+
+func (m *mockFIPController) KnownIP(ip string) bool {
+	return m.known.Has(ip)
+}
+
+func (m *mockFIPController) SharedNode(*corev1.Service, stringset.StringSet) (string, bool) {
+	return "", false
+}
+
+func (m *mockFIPController) CurrentNode(_ stringset.StringSet, candidates []*corev1.Node) (string, bool) {
+	for _, node := range candidates {
+		if node.Name == m.current {
+			return node.Name, true
+		}
+	}
+	return "", false
+}
+
+func (m *mockFIPController) LastMove(stringset.StringSet) time.Time {
+	return m.lastMove
+}
+
+func (m *mockFIPController) AttachToNode(context.Context, *corev1.Service, stringset.StringSet, *corev1.Node) error {
+	return nil
+}
+
+func (m *mockFIPController) ForgetAttachments(svcIPs stringset.StringSet) {
+	if m.forgotten == nil {
+		m.forgotten = make(stringset.StringSet)
+	}
+	for ip := range svcIPs {
+		m.forgotten.Add(ip)
+	}
+}
+
+func newTestLogger() logrus.FieldLogger {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	return logger
+}
+
+func newStringSet(items ...string) stringset.StringSet {
+	s := make(stringset.StringSet, len(items))
+	for _, item := range items {
+		s.Add(item)
+	}
+	return s
+}
+
+func sortedStrings(s stringset.StringSet) []string {
+	items := make([]string, 0, len(s))
+	for item := range s {
+		items = append(items, item)
+	}
+	sort.Strings(items)
+	return items
+}
+
+func TestUnsupportedServiceType(t *testing.T) {
+	const ourClass = "example.com/floating-ip"
+	strPtr := func(s string) *string { return &s }
+
+	tests := []struct {
+		name      string
+		svcType   corev1.ServiceType
+		class     *string
+		ips       []string
+		unclassed string
+		want      bool
+	}{
+		{"not a load balancer", corev1.ServiceTypeClusterIP, nil, nil, UnclassedClaim, true},
+		{"our class", corev1.ServiceTypeLoadBalancer, strPtr(ourClass), nil, UnclassedIgnore, false},
+		{"foreign class", corev1.ServiceTypeLoadBalancer, strPtr("example.com/other"), nil, UnclassedClaim, true},
+		{"unclassed: claim", corev1.ServiceTypeLoadBalancer, nil, nil, UnclassedClaim, false},
+		{"unclassed: ignore", corev1.ServiceTypeLoadBalancer, nil, []string{"192.0.2.1"}, UnclassedIgnore, true},
+		{"unclassed: known FIP", corev1.ServiceTypeLoadBalancer, nil, []string{"198.51.100.1", "192.0.2.1"}, UnclassedKnownFIP, false},
+		{"unclassed: unknown IP", corev1.ServiceTypeLoadBalancer, nil, []string{"198.51.100.1"}, UnclassedKnownFIP, true},
+		{"unclassed: no IP", corev1.ServiceTypeLoadBalancer, nil, nil, UnclassedKnownFIP, true},
+	}
+
+	saved := config.Global
+	t.Cleanup(func() { config.Global = saved })
+	config.Global.LoadBalancerClass = ourClass
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config.Global.UnclassedServices = tt.unclassed
+
+			sc := &Controller{
+				Logger: newTestLogger(),
+				FIPc:   &mockFIPController{known: newStringSet("192.0.2.1")},
+			}
+
+			svc := &corev1.Service{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "svc"},
+				Spec:       corev1.ServiceSpec{Type: tt.svcType, LoadBalancerClass: tt.class},
+			}
+			for _, ip := range tt.ips {
+				svc.Status.LoadBalancer.Ingress = append(svc.Status.LoadBalancer.Ingress, corev1.LoadBalancerIngress{IP: ip})
+			}
+
+			if got := sc.unsupportedServiceType(svc); got != tt.want {
+				t.Errorf("got %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServiceIPsBookkeeping(t *testing.T) {
+	fipc := &mockFIPController{}
+	sc := &Controller{
+		Logger: newTestLogger(),
+		FIPc:   fipc,
+		svcIPs: make(map[string]stringset.StringSet),
+	}
+
+	steps := []struct {
+		name          string
+		run           func()
+		wantIPs       []string // recorded for default/a
+		wantForgotten []string // all forgotten attachments so far
+	}{
+		{
+			name:    "add",
+			run:     func() { sc.updateServiceIPs("default/a", newStringSet("192.0.2.1", "192.0.2.2")) },
+			wantIPs: []string{"192.0.2.1", "192.0.2.2"},
+		},
+		{
+			name:          "change",
+			run:           func() { sc.updateServiceIPs("default/a", newStringSet("192.0.2.2", "192.0.2.3")) },
+			wantIPs:       []string{"192.0.2.2", "192.0.2.3"},
+			wantForgotten: []string{"192.0.2.1"},
+		},
+		{
+			name:          "other service",
+			run:           func() { sc.updateServiceIPs("default/b", newStringSet("192.0.2.4")) },
+			wantIPs:       []string{"192.0.2.2", "192.0.2.3"},
+			wantForgotten: []string{"192.0.2.1"},
+		},
+		{
+			name:          "remove",
+			run:           func() { sc.forgetServiceIPs("default/a") },
+			wantForgotten: []string{"192.0.2.1", "192.0.2.2", "192.0.2.3"},
+		},
+		{
+			name:          "no IPs",
+			run:           func() { sc.updateServiceIPs("default/b", newStringSet()) },
+			wantForgotten: []string{"192.0.2.1", "192.0.2.2", "192.0.2.3", "192.0.2.4"},
+		},
+	}
+
+	for _, step := range steps {
+		step.run()
+
+		if got := sortedStrings(sc.svcIPs["default/a"]); !reflect.DeepEqual(got, append([]string{}, step.wantIPs...)) {
+			t.Errorf("%s: got IPs %v, want %v", step.name, got, step.wantIPs)
+		}
+		if got := sortedStrings(fipc.forgotten); !reflect.DeepEqual(got, append([]string{}, step.wantForgotten...)) {
+			t.Errorf("%s: got forgotten %v, want %v", step.name, got, step.wantForgotten)
+		}
+	}
+
+	if len(sc.svcIPs) != 0 {
+		t.Errorf("got leftover IPs %v", sc.svcIPs)
+	}
+}
+
+func TestAnnotationsChanged(t *testing.T) {
+	tests := []struct {
+		name     string
+		old, new map[string]string
+		want     bool
+	}{
+		{"none", nil, nil, false},
+		{"unchanged", map[string]string{pinnedNodeAnnotation: "a"}, map[string]string{pinnedNodeAnnotation: "a"}, false},
+		{"added", nil, map[string]string{pinnedNodeAnnotation: "a"}, true},
+		{"removed", map[string]string{pinnedNodeAnnotation: "a"}, nil, true},
+		{"changed", map[string]string{pinnedNodeAnnotation: "a"}, map[string]string{pinnedNodeAnnotation: "b"}, true},
+		{"emptied", map[string]string{preferredNodesAnnotation: "a"}, map[string]string{preferredNodesAnnotation: ""}, true},
+		{"foreign added", nil, map[string]string{"example.com/other": "a"}, false},
+		{"foreign changed", map[string]string{"example.com/other": "a"}, map[string]string{"example.com/other": "b"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			oldSvc := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Annotations: tt.old}}
+			newSvc := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Annotations: tt.new}}
+
+			if got := annotationsChanged(oldSvc, newSvc); got != tt.want {
+				t.Errorf("got %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
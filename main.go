@@ -1,18 +1,34 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"net/http"
 	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/hetznercloud/hcloud-go/hcloud"
 	"github.com/sirupsen/logrus"
 	"github.com/stevenroose/gonfig"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/client-go/tools/record"
 
 	"github.com/costela/hcloud-ip-floater/internal/config"
 	"github.com/costela/hcloud-ip-floater/internal/fipcontroller"
+	"github.com/costela/hcloud-ip-floater/internal/metrics"
 	"github.com/costela/hcloud-ip-floater/internal/servicecontroller"
 )
 
@@ -37,6 +53,45 @@ func main() {
 		os.Exit(0)
 	}
 
+	if config.Global.HCloudToken == "" && config.Global.RobotUser == "" && config.Global.ExternalCommand == "" && config.Global.ExternalWebhook == "" {
+		logger.Fatal("an hcloud token, Hetzner Robot credentials or an external command or webhook are required")
+	}
+
+	if _, err := labels.Parse(config.Global.NodeLabelSelector); err != nil {
+		logger.Fatalf("invalid node label selector: %s", err)
+	}
+
+	if _, err := servicecontroller.ParseExcludedTaints(config.Global.ExcludedTaints); err != nil {
+		logger.Fatalf("invalid excluded taints: %s", err)
+	}
+
+	if _, err := fipcontroller.ParseIPRanges(config.Global.ExternalIPRanges); err != nil {
+		logger.Fatalf("invalid external IP ranges: %s", err)
+	}
+
+	// a single attachment may take up to the attach timeout without the controller being stuck
+	if config.Global.HealthDeadlineSeconds <= config.Global.AttachTimeoutSeconds {
+		logger.Fatalf("health deadline (%ds) must exceed the attach timeout (%ds)", config.Global.HealthDeadlineSeconds, config.Global.AttachTimeoutSeconds)
+	}
+
+	if config.Global.Workers < 1 {
+		logger.Fatalf("invalid number of workers: %d", config.Global.Workers)
+	}
+
+	if !fipcontroller.ValidNodeMapping(config.Global.NodeMapping) {
+		logger.Fatalf("invalid node mapping: %s", config.Global.NodeMapping)
+	}
+
+	switch config.Global.UnclassedServices {
+	case servicecontroller.UnclassedClaim, servicecontroller.UnclassedIgnore, servicecontroller.UnclassedKnownFIP:
+	default:
+		logger.Fatalf("invalid policy for unclassed services: %s", config.Global.UnclassedServices)
+	}
+
+	if config.Global.MetalLBMode != fipcontroller.MetalLBModeConfigMap && config.Global.MetalLBMode != fipcontroller.MetalLBModeCRD {
+		logger.Fatalf("invalid MetalLB mode: %s", config.Global.MetalLBMode)
+	}
+
 	if level, err := logrus.ParseLevel(config.Global.LogLevel); err != nil {
 		logger.Fatalf("could not set log level to %s: %s", config.Global.LogLevel, err)
 	} else {
@@ -45,6 +100,9 @@ func main() {
 
 	logger.WithFields(logrus.Fields{"version": version}).Info("starting hcloud IP floater")
 
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+
 	var k8sCfg *rest.Config
 	if os.Getenv("KUBERNETES_SERVICE_HOST") != "" {
 		cfg, err := rest.InClusterConfig()
@@ -65,22 +123,172 @@ func main() {
 		logger.Fatalf("could not init k8s client: %s", err)
 	}
 
+	dyn, err := dynamic.NewForConfig(k8sCfg)
+	if err != nil {
+		logger.Fatalf("could not init dynamic k8s client: %s", err)
+	}
+
 	hcc := hcloud.NewClient(
 		hcloud.WithApplication(serviceName, version),
 		hcloud.WithToken(config.Global.HCloudToken),
 		hcloud.WithDebugWriter(logger.WithFields(logrus.Fields{"component": "hcloud"}).WriterLevel(logrus.DebugLevel)),
+		hcloud.WithHTTPClient(&http.Client{
+			Transport: metrics.InstrumentRoundTripper(http.DefaultTransport),
+		}),
 	)
 
-	fipc := fipcontroller.New(logger, hcc)
+	eventBroadcaster := record.NewBroadcaster()
+	eventBroadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: k8s.CoreV1().Events("")})
+	recorder := eventBroadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: serviceName})
+
+	fipc := fipcontroller.New(logger, hcc, k8s, dyn, recorder)
+
+	elected := make(chan struct{})
 
 	sc := servicecontroller.Controller{
-		Logger: logger,
-		K8S:    k8s,
-		FIPc:   fipc,
+		Logger:   logger,
+		K8S:      k8s,
+		FIPc:     fipc,
+		Recorder: recorder,
+		Elected:  elected,
 	}
 
-	go fipc.Run()
-	go sc.Run()
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		if err := fipc.Healthy(time.Duration(config.Global.HealthDeadlineSeconds) * time.Second); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		// standbys keep their caches in sync too, so they are only ready once they can take over right away
+		if !sc.HasSynced() {
+			http.Error(w, "informers not synced", http.StatusServiceUnavailable)
+			return
+		} else if !fipc.Synced() {
+			http.Error(w, "floating IPs not synced", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+
+	srv := &http.Server{
+		Addr:    config.Global.ListenAddress,
+		Handler: mux,
+	}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logger.Fatalf("could not serve HTTP: %s", err)
+		}
+	}()
+	defer func() {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Duration(config.Global.ShutdownGraceSeconds)*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			logger.WithError(err).Warn("could not shut down HTTP server")
+		}
+	}()
 
-	select {}
+	// all replicas watch the cluster and poll hcloud, so a standby can fail over right after taking over
+	var controllers sync.WaitGroup
+	controllers.Add(2)
+	go func() {
+		defer controllers.Done()
+		fipc.Run(ctx)
+	}()
+	go func() {
+		defer controllers.Done()
+		sc.Run(ctx)
+	}()
+
+	lead := func() {
+		if ctx.Err() != nil {
+			return
+		}
+
+		fipc.SetLeading(true)
+		close(elected)
+	}
+
+	if !config.Global.LeaderElection {
+		lead()
+		<-ctx.Done()
+		logger.Info("shutting down")
+		waitForControllers(logger, &controllers)
+		return
+	}
+
+	identity := config.Global.LeaderElectionIdentity
+	if identity == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			logger.Fatalf("could not determine leader election identity: %s", err)
+		}
+		identity = hostname
+	}
+
+	leLogger := logger.WithFields(logrus.Fields{"component": "leaderelection", "identity": identity})
+
+	// the lease is only released once the controllers stopped, so a new leader doesn't race with our last attachments
+	leCtx, cancelLE := context.WithCancel(context.Background())
+	go func() {
+		<-ctx.Done()
+		logger.Info("shutting down")
+		waitForControllers(logger, &controllers)
+		fipc.SetLeading(false)
+		cancelLE()
+	}()
+
+	leaderelection.RunOrDie(leCtx, leaderelection.LeaderElectionConfig{
+		Lock: &resourcelock.LeaseLock{
+			LeaseMeta: metav1.ObjectMeta{
+				Namespace: config.Global.LeaderElectionNamespace,
+				Name:      config.Global.LeaderElectionName,
+			},
+			Client: k8s.CoordinationV1(),
+			LockConfig: resourcelock.ResourceLockConfig{
+				Identity: identity,
+			},
+		},
+		LeaseDuration:   time.Duration(config.Global.LeaseSeconds) * time.Second,
+		RenewDeadline:   time.Duration(config.Global.RenewSeconds) * time.Second,
+		RetryPeriod:     time.Duration(config.Global.RetrySeconds) * time.Second,
+		Name:            config.Global.LeaderElectionName,
+		ReleaseOnCancel: true,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(context.Context) {
+				leLogger.Info("acquired leadership")
+				lead()
+			},
+			OnStoppedLeading: func() {
+				if ctx.Err() != nil {
+					leLogger.Info("released leadership")
+					return
+				}
+				// controllers cannot be cleanly restarted, so step down completely and let k8s restart us as a standby
+				fipc.SetLeading(false)
+				leLogger.Fatal("lost leadership")
+			},
+			OnNewLeader: func(leader string) {
+				leLogger.WithField("leader", leader).Info("observed new leader")
+			},
+		},
+	})
+}
+
+// waitForControllers waits for running controllers to stop, but no longer than the shutdown grace period
+func waitForControllers(logger *logrus.Logger, controllers *sync.WaitGroup) {
+	done := make(chan struct{})
+	go func() {
+		controllers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Duration(config.Global.ShutdownGraceSeconds) * time.Second):
+		logger.Warn("controllers did not stop within shutdown grace period")
+	}
 }